@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 type KeyboardFlag struct {
@@ -49,3 +50,78 @@ func (f *HoldFlag) Set(value string) (err error) {
 func (f *HoldFlag) String() string {
 	return fmt.Sprintf("%v", f.Value)
 }
+
+type ChoiceFlag struct {
+	Value   string
+	IsSet   bool
+	Choices []string
+}
+
+func (f *ChoiceFlag) Set(value string) (err error) {
+	if err = f.Validate(value); err != nil {
+		return
+	}
+	f.Value = value
+	f.IsSet = true
+	return
+}
+
+// Validate checks that value is one of the choices
+func (f *ChoiceFlag) Validate(value string) error {
+	for _, choice := range f.Choices {
+		if value == choice {
+			return nil
+		}
+	}
+	return fmt.Errorf("must be one of %s", strings.Join(f.Choices, ", "))
+}
+
+func (f *ChoiceFlag) String() string {
+	return fmt.Sprintf("%v", f.Value)
+}
+
+type IntFlag struct {
+	Value int
+	IsSet bool
+}
+
+func (f *IntFlag) Set(value string) (err error) {
+	f.Value, err = strconv.Atoi(value)
+	f.IsSet = true
+	return
+}
+
+func (f *IntFlag) String() string {
+	return fmt.Sprintf("%v", f.Value)
+}
+
+type FloatFlag struct {
+	Value float64
+	IsSet bool
+}
+
+func (f *FloatFlag) Set(value string) (err error) {
+	f.Value, err = strconv.ParseFloat(value, 32)
+	f.IsSet = true
+	return
+}
+
+func (f *FloatFlag) String() string {
+	return fmt.Sprintf("%v", f.Value)
+}
+
+// StringFlag is a plain string value like a name, a path or a URL
+type StringFlag struct {
+	Value string
+	IsSet bool
+}
+
+func (f *StringFlag) Set(value string) (err error) {
+	f.Value = value
+	f.IsSet = true
+	return
+}
+
+func (f *StringFlag) String() string {
+	return f.Value
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/moutend/go-hook/pkg/types"
+)
+
+var procGetAsyncKeyState = user32.NewProc("GetAsyncKeyState")
+
+// AsyncKeyState reads the physical key state through GetAsyncKeyState
+type AsyncKeyState struct{}
+
+func (s AsyncKeyState) IsDown(vk int) (bool, error) {
+	res, _, _ := procGetAsyncKeyState.Call(uintptr(vk))
+	//? The most significant bit of the SHORT is set while the key is down
+	return res&0x8000 != 0, nil
+}
+
+// keyCode returns the virtual key code for a -keybind value like VK_A
+func keyCode(name string) (int, error) {
+	for vk := 0; vk < 256; vk++ {
+		if fmt.Sprint(types.VKCode(vk)) == name {
+			return vk, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key %q", name)
+}
+
+// mouseButtonCode returns the virtual key code of the button a -mousedown message and -mousedata belong to
+func mouseButtonCode(message int, data int) (int, error) {
+	const (
+		WM_LBUTTONDOWN = 0x0201
+		WM_RBUTTONDOWN = 0x0204
+		WM_MBUTTONDOWN = 0x0207
+		WM_XBUTTONDOWN = 0x020B
+		XBUTTON1       = 0x0001 << 16
+		XBUTTON2       = 0x0002 << 16
+	)
+	switch message {
+	case WM_LBUTTONDOWN:
+		return 0x01, nil // VK_LBUTTON
+	case WM_RBUTTONDOWN:
+		return 0x02, nil // VK_RBUTTON
+	case WM_MBUTTONDOWN:
+		return 0x04, nil // VK_MBUTTON
+	case WM_XBUTTONDOWN:
+		switch data {
+		case XBUTTON1:
+			return 0x05, nil // VK_XBUTTON1
+		case XBUTTON2:
+			return 0x06, nil // VK_XBUTTON2
+		}
+	}
+	return 0, fmt.Errorf("can't read the state of mouse message %v with data %v", message, data)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"Muteiny/ipc"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+
+	"github.com/go-ole/go-ole"
+)
+
+// Status is the engine state reported to the control API
+type Status struct {
+	Open     bool   `json:"open"`
+	Deafened bool   `json:"deafened"`
+	Panicked bool   `json:"panicked"`
+	Device   string `json:"device"`
+	Profile  string `json:"profile"`
+}
+
+// DeviceInfo is a capture device reported by list-devices
+type DeviceInfo struct {
+	Name    string `json:"name"`
+	Muted   bool   `json:"muted"`
+	Default bool   `json:"default"`
+}
+
+// Status returns the current engine state
+func (e *Engine) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	profileName, _ := ActiveProfile()
+	return Status{Open: e.open, Deafened: e.deafened, Panicked: e.panicked, Device: e.device, Profile: profileName}
+}
+
+// withOLE runs fn on a locked thread with COM initialized, the control API calls come from their own goroutines
+func withOLE(fn func()) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	InitOLE()
+	defer ole.CoUninitialize()
+	fn()
+}
+
+// engineMethod wraps an engine action as a control method that answers with the new status
+func engineMethod(action func()) ipc.Handler {
+	return func(params json.RawMessage) (interface{}, error) {
+		withOLE(action)
+		return engine.Status(), nil
+	}
+}
+
+// NewControlServer creates the control API server, it drives the same engine as the bindings
+func NewControlServer() *ipc.Server {
+	server := ipc.NewServer()
+	server.Handle("mute", engineMethod(func() { engine.SetOpen(false) }))
+	server.Handle("unmute", engineMethod(func() { engine.SetOpen(true) }))
+	server.Handle("toggle", engineMethod(engine.Toggle))
+	server.Handle("press", engineMethod(engine.PressRemote))
+	server.Handle("release", engineMethod(engine.Release))
+	server.Handle("status", func(params json.RawMessage) (interface{}, error) {
+		return engine.Status(), nil
+	})
+	server.Handle("set-profile", func(params json.RawMessage) (interface{}, error) {
+		var args struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, ipc.InvalidParams(err)
+		}
+		if args.Name == "" {
+			return nil, ipc.InvalidParams(errors.New("name is required"))
+		}
+		if err := ChangeProfile(args.Name); err != nil {
+			return nil, ipc.InvalidParams(err)
+		}
+		return engine.Status(), nil
+	})
+	server.Handle("list-devices", func(params json.RawMessage) (interface{}, error) {
+		infos := []DeviceInfo{}
+		defaultDevice := engine.DeviceName()
+		withOLE(func() {
+			devices, releaseAll := GetAllDevices()
+			defer releaseAll()
+			for deviceName, device := range devices {
+				infos = append(infos, DeviceInfo{Name: deviceName, Muted: GetMute(device), Default: deviceName == defaultDevice})
+			}
+		})
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+		return infos, nil
+	})
+	return server
+}
+
+// ChangeProfile switches the active profile while running
+func ChangeProfile(name string) error {
+	if err := SetProfile(name); err != nil {
+		return err
+	}
+	profileName, _ := ActiveProfile()
+	fmt.Println("Profile changed to:", profileName)
+	if profileMenu != nil {
+		profileMenu.SetTitle("Profile: " + profileName)
+	}
+	status := engine.Status()
+	Publish(Event{Type: "profile", Open: status.Open, Deafened: status.Deafened, Device: status.Device, Profile: profileName})
+	return nil
+}
+
+// RunControlServer serves the control API until the returned function is called
+func RunControlServer(name string) (func(), error) {
+	listener, err := ipc.Listen(name)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Control API listening on", ipc.Path(name))
+	done := make(chan struct{})
+	go func() {
+		NewControlServer().Serve(listener)
+		close(done)
+	}()
+	return func() {
+		listener.Close()
+		<-done
+	}, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"Muteiny/sound"
+	"fmt"
+	"time"
+)
+
+// NewCues loads the open and close cues from the -cue flags
+func NewCues(player sound.Player) (*sound.Cues, error) {
+	duration := time.Duration(cueDurationFlag.Value) * time.Millisecond
+	openCue, err := sound.LoadCue(cueOpenWavFlag.Value, cueOpenFreqFlag.Value, duration, cueVolumeFlag.Value)
+	if err != nil {
+		return nil, err
+	}
+	closeCue, err := sound.LoadCue(cueCloseWavFlag.Value, cueCloseFreqFlag.Value, duration, cueVolumeFlag.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &sound.Cues{
+		Player: player,
+		Open:   openCue,
+		Close:  closeCue,
+		OnError: func(err error) {
+			fmt.Println("Error playing cue", err)
+		},
+	}, nil
+}
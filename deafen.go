@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Original mute state of the render devices Muteiny deafened, restored on undeafen and shutdown
+var renderStatesMap map[string]bool = make(map[string]bool)
+
+// IsDeafened returns true while the output and the mic are muted by the deafen binding
+func (e *Engine) IsDeafened() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.deafened
+}
+
+// SetDeafened mutes the default render device together with the mic, or restores both
+func (e *Engine) SetDeafened(deafen bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.deafened == deafen {
+		return
+	}
+	e.deafened = deafen
+	if deafen {
+		fmt.Println("Deafened")
+		deafenRenderDevice()
+	} else {
+		fmt.Println("Undeafened")
+		RestoreRenderDevices()
+	}
+	//? apply keeps the mic muted while deafened and puts it back to the push-to-talk state after
+	e.apply()
+	Publish(Event{Type: "deafen", Open: e.open, Deafened: deafen, Device: e.device})
+}
+
+// ToggleDeafen flips the deafen state, used by -deafenmode toggle
+func (e *Engine) ToggleDeafen() {
+	e.SetDeafened(!e.IsDeafened())
+}
+
+// deafenRenderDevice mutes the default render device, remembering its original state
+func deafenRenderDevice() {
+	aev, deviceName, release, err := GetDefaultRenderDevice()
+	if err != nil {
+		fmt.Println("Error getting default output device", err)
+		return
+	}
+	defer release()
+	if _, ok := renderStatesMap[deviceName]; !ok {
+		mute := GetMute(aev)
+		renderStatesMap[deviceName] = mute
+		UpdateState(func(state *SavedState) {
+			state.RenderMuteStates[deviceName] = mute
+		})
+	}
+	fmt.Println("Muting output device:", deviceName)
+	if err := SetMute(aev, true); err != nil {
+		fmt.Println("Error muting output device:", deviceName, err)
+	}
+}
+
+// RestoreRenderDevices restores every render device Muteiny deafened to its original mute state
+func RestoreRenderDevices() {
+	if len(renderStatesMap) == 0 {
+		return
+	}
+	devices, releaseAll := GetAllRenderDevices()
+	defer releaseAll()
+	for deviceName, muteState := range renderStatesMap {
+		if device := devices[deviceName]; device != nil {
+			fmt.Println("Restoring output mute state for:", deviceName, "to:", muteState)
+			if muteState != GetMute(device) {
+				if err := SetMute(device, muteState); err != nil {
+					fmt.Println("Error setting mute state for:", deviceName, err)
+					continue
+				}
+			}
+		} else {
+			//? Keep it for the next launch, the device may just be unplugged
+			fmt.Println("Output device not found:", deviceName)
+			continue
+		}
+		delete(renderStatesMap, deviceName)
+		UpdateState(func(state *SavedState) {
+			delete(state.RenderMuteStates, deviceName)
+		})
+	}
+}
+
+// RestoreSavedRenderDevices restores render devices left deafened by a session that didn't shut down cleanly
+func RestoreSavedRenderDevices() {
+	ReadState(func(state *SavedState) {
+		for deviceName, muteState := range state.RenderMuteStates {
+			renderStatesMap[deviceName] = muteState
+		}
+	})
+	if len(renderStatesMap) > 0 {
+		fmt.Println("Found deafened output devices from a previous session, restoring them")
+		RestoreRenderDevices()
+	}
+}
@@ -3,16 +3,74 @@ package main
 import (
 	"fmt"
 	"os"
+	"runtime"
+	"sync"
 
 	"github.com/go-ole/go-ole"
 	"github.com/moutend/go-wca/pkg/wca"
 )
 
+// Guards usedDevices and deviceStatesMap, the engine, the watchers and the tray all reach them
+var deviceMapsMutex sync.Mutex
+
 // Used to store the name of the selected input device
 // Which is used to restore the mute state
 var usedDevices map[string]bool = make(map[string]bool)
 
-var _lastDeviceName string = "Unknown"
+// Store the original mute state of the devices
+var deviceStatesMap map[string]bool = make(map[string]bool)
+
+// RememberOriginalMute stores the mute state a device had before Muteiny touched it, also in the state file
+func RememberOriginalMute(deviceName string, mute bool) {
+	deviceMapsMutex.Lock()
+	deviceStatesMap[deviceName] = mute
+	deviceMapsMutex.Unlock()
+	UpdateState(func(state *SavedState) {
+		state.MuteStates[deviceName] = mute
+	})
+}
+
+// OriginalMute returns the mute state a device had before Muteiny touched it, false if there is no snapshot
+func OriginalMute(deviceName string) (mute bool, ok bool) {
+	deviceMapsMutex.Lock()
+	defer deviceMapsMutex.Unlock()
+	mute, ok = deviceStatesMap[deviceName]
+	return mute, ok
+}
+
+// UsedDeviceSnapshots returns the original mute states of the devices Muteiny changed
+func UsedDeviceSnapshots() map[string]bool {
+	deviceMapsMutex.Lock()
+	defer deviceMapsMutex.Unlock()
+	states := make(map[string]bool)
+	for deviceName, mute := range deviceStatesMap {
+		if usedDevices[deviceName] {
+			states[deviceName] = mute
+		}
+	}
+	return states
+}
+
+// MarkDeviceUsed flags the device to be restored on shutdown, also in the state file
+func MarkDeviceUsed(deviceName string) {
+	deviceMapsMutex.Lock()
+	if usedDevices[deviceName] {
+		deviceMapsMutex.Unlock()
+		return
+	}
+	usedDevices[deviceName] = true
+	deviceMapsMutex.Unlock()
+	UpdateState(func(state *SavedState) {
+		state.UsedDevices[deviceName] = true
+	})
+}
+
+// IsDeviceUsed returns true if Muteiny has changed the device
+func IsDeviceUsed(deviceName string) bool {
+	deviceMapsMutex.Lock()
+	defer deviceMapsMutex.Unlock()
+	return usedDevices[deviceName]
+}
 
 func SetDefaultDeviceName(name string) {
 	// inputDeviceMenu is of type *systray.MenuItem
@@ -22,13 +80,28 @@ func SetDefaultDeviceName(name string) {
 }
 
 func InitOLE() {
+	const S_FALSE = 0x00000001
 	if err := ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED); err != nil {
+		//? S_FALSE means COM was already initialized on this thread, which happens as goroutines share threads
+		if oleErr, ok := err.(*ole.OleError); ok && oleErr.Code() == S_FALSE {
+			return
+		}
 		fmt.Println("Error initializing COM", err)
 		os.Exit(1)
 	}
 }
 
+// GetAllDevices returns every active capture device by name
 func GetAllDevices() (map[string]*wca.IAudioEndpointVolume, func()) {
+	return getAllEndpoints(wca.ECapture)
+}
+
+// GetAllRenderDevices returns every active render (speaker/headphone) device by name
+func GetAllRenderDevices() (map[string]*wca.IAudioEndpointVolume, func()) {
+	return getAllEndpoints(wca.ERender)
+}
+
+func getAllEndpoints(flow uint32) (map[string]*wca.IAudioEndpointVolume, func()) {
 	var devices map[string]*wca.IAudioEndpointVolume = make(map[string]*wca.IAudioEndpointVolume)
 	var releaseFuncs []func()
 
@@ -39,7 +112,7 @@ func GetAllDevices() (map[string]*wca.IAudioEndpointVolume, func()) {
 	}
 
 	var pDevices *wca.IMMDeviceCollection
-	if err := mmde.EnumAudioEndpoints(wca.ECapture, wca.DEVICE_STATE_ACTIVE, &pDevices); err != nil {
+	if err := mmde.EnumAudioEndpoints(flow, wca.DEVICE_STATE_ACTIVE, &pDevices); err != nil {
 		fmt.Println("Error enumerating devices", err)
 		os.Exit(1)
 	}
@@ -98,7 +171,8 @@ func GetAllDevices() (map[string]*wca.IAudioEndpointVolume, func()) {
 	}
 }
 
-func GetDefaultDevice() (*wca.IAudioEndpointVolume, func()) {
+// GetDefaultDevice returns the default capture device and its name, the engine decides whether it is a new device
+func GetDefaultDevice() (*wca.IAudioEndpointVolume, string, func()) {
 	// //? Here start the fetching of the default communications device
 
 	var mmde *wca.IMMDeviceEnumerator
@@ -127,11 +201,7 @@ func GetDefaultDevice() (*wca.IAudioEndpointVolume, func()) {
 		os.Exit(1)
 	}
 
-	_lastDeviceName = fmt.Sprint(pv.String())
-	fmt.Printf("Input Device: %s\n", _lastDeviceName)
-	// Set the device as used, used when restoring mute state
-	usedDevices[_lastDeviceName] = true
-	SetDefaultDeviceName(_lastDeviceName)
+	deviceName := fmt.Sprint(pv.String())
 
 	//? Get the audio endpoint to control the settings of the device.
 	var aev *wca.IAudioEndpointVolume
@@ -139,7 +209,7 @@ func GetDefaultDevice() (*wca.IAudioEndpointVolume, func()) {
 		fmt.Println("Error activating audio endpoint", err)
 		os.Exit(1)
 	}
-	return aev,
+	return aev, deviceName,
 		func() {
 			// defer ole.CoUninitialize()
 			defer mmde.Release()
@@ -148,3 +218,152 @@ func GetDefaultDevice() (*wca.IAudioEndpointVolume, func()) {
 			defer aev.Release()
 		}
 }
+
+// GetDefaultRenderDevice returns the default render (speaker/headphone) device and its name.
+// Unlike the capture device it is fine for there to be none, so errors are returned.
+func GetDefaultRenderDevice() (*wca.IAudioEndpointVolume, string, func(), error) {
+	var mmde *wca.IMMDeviceEnumerator
+	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
+		return nil, "", nil, err
+	}
+	defer mmde.Release()
+
+	var mmd *wca.IMMDevice
+	if err := mmde.GetDefaultAudioEndpoint(wca.ERender, wca.DEVICE_STATE_ACTIVE, &mmd); err != nil {
+		return nil, "", nil, err
+	}
+	defer mmd.Release()
+
+	var ps *wca.IPropertyStore
+	if err := mmd.OpenPropertyStore(wca.STGM_READ, &ps); err != nil {
+		return nil, "", nil, err
+	}
+	defer ps.Release()
+
+	var pv wca.PROPVARIANT
+	if err := ps.GetValue(&wca.PKEY_Device_FriendlyName, &pv); err != nil {
+		return nil, "", nil, err
+	}
+
+	var aev *wca.IAudioEndpointVolume
+	if err := mmd.Activate(wca.IID_IAudioEndpointVolume, wca.CLSCTX_ALL, nil, &aev); err != nil {
+		return nil, "", nil, err
+	}
+	return aev, pv.String(), func() { aev.Release() }, nil
+}
+
+// WatchDefaultDevice calls onChange every time the default capture device changes.
+// The callback runs on its own OLE initialized thread, the returned function stops the watcher.
+func WatchDefaultDevice(onChange func()) func() {
+	changed := make(chan struct{}, 1)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		InitOLE()
+		defer ole.CoUninitialize()
+
+		var mmde *wca.IMMDeviceEnumerator
+		if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
+			fmt.Println("Error creating device enumerator", err)
+			return
+		}
+		defer mmde.Release()
+
+		//? The notification arrives on a COM thread, we must not call back into COM from there
+		mmnc := wca.NewIMMNotificationClient(wca.IMMNotificationClientCallback{
+			OnDefaultDeviceChanged: func(flow wca.EDataFlow, role wca.ERole, pwstrDeviceId string) error {
+				if flow == wca.ECapture {
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				}
+				return nil
+			},
+		})
+		if err := mmde.RegisterEndpointNotificationCallback(mmnc); err != nil {
+			fmt.Println("Error registering device notification callback", err)
+			return
+		}
+		defer mmde.UnregisterEndpointNotificationCallback(mmnc)
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-changed:
+				onChange()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// WatchMute calls onChange every time the mute state of the current default capture device changes.
+// It is called once with the current state when the watcher starts, the returned function stops the watcher.
+func WatchMute(onChange func(aev *wca.IAudioEndpointVolume, deviceName string, muted bool, volume float32, external bool)) func() {
+	type muteNotification struct {
+		muted    bool
+		volume   float32
+		external bool
+	}
+	//? Only the newest state matters, a burst of changes is coalesced into the latest one
+	var latestMu sync.Mutex
+	var latest muteNotification
+	notified := make(chan struct{}, 1)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		InitOLE()
+		defer ole.CoUninitialize()
+
+		aev, deviceName, release := GetDefaultDevice()
+		defer release()
+
+		//? The notification arrives on a COM thread, we must not call back into COM from there
+		callback := NewAudioEndpointVolumeCallback(func(muted bool, volume float32, external bool) {
+			latestMu.Lock()
+			latest = muteNotification{muted, volume, external}
+			latestMu.Unlock()
+			select {
+			case notified <- struct{}{}:
+			default:
+			}
+		})
+		if err := RegisterControlChangeNotify(aev, callback); err != nil {
+			fmt.Println("Error registering mute change callback", err)
+			return
+		}
+		defer UnregisterControlChangeNotify(aev, callback)
+
+		onChange(aev, deviceName, GetMute(aev), GetVolume(aev), false)
+		for {
+			select {
+			case <-done:
+				return
+			case <-notified:
+				latestMu.Lock()
+				n := latest
+				latestMu.Unlock()
+				onChange(aev, deviceName, n.muted, n.volume, n.external)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
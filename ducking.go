@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+	"golang.org/x/sys/windows"
+)
+
+// Ducker lowers the output while the mic is open so it doesn't bleed into the mic
+type Ducker struct {
+	Factor float32  // What is left of the volume while ducked, 0.7 for -duck 30
+	Apps   []string // Lower case process names to duck, empty ducks the whole default output device
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+// The ducker of this session, nil when -duck is not set
+var ducker *Ducker
+
+// NewDucker creates a ducker that lowers the volume by percent, apps is a comma separated list of process names
+func NewDucker(percent int, apps string) *Ducker {
+	d := &Ducker{Factor: 1 - float32(percent)/100}
+	for _, app := range strings.Split(apps, ",") {
+		if app = strings.ToLower(strings.TrimSpace(app)); app != "" {
+			d.Apps = append(d.Apps, app)
+		}
+	}
+	return d
+}
+
+// StateChanged ducks when the mic opens and restores when it closes, the close comes after the hold time
+func (d *Ducker) StateChanged(open bool) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	InitOLE()
+	defer ole.CoUninitialize()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+	if open {
+		d.duck()
+	} else {
+		RestoreDucking()
+	}
+}
+
+// Stop restores the output and ignores later events, called on shutdown
+func (d *Ducker) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopped = true
+	RestoreDucking()
+}
+
+func (d *Ducker) duck() {
+	if len(d.Apps) == 0 {
+		d.duckDevice()
+		return
+	}
+	err := forEachRenderSession(func(session renderSession, sav *wca.ISimpleAudioVolume) {
+		if !d.isDucked(session.Process) {
+			return
+		}
+		var level float32
+		if err := sav.GetMasterVolume(&level); err != nil {
+			fmt.Println("Error getting session volume for:", session.Process, err)
+			return
+		}
+		//? Every session keeps its own original level, a process can have several at different levels
+		var original float32
+		UpdateState(func(state *SavedState) {
+			if saved, ok := state.SessionVolumes[session.ID]; ok {
+				original = saved.Level
+			} else {
+				state.SessionVolumes[session.ID] = SessionVolume{Process: session.Process, Level: level}
+				original = level
+			}
+		})
+		if err := sav.SetMasterVolume(original*d.Factor, muteinyEventContext); err != nil {
+			fmt.Println("Error ducking:", session.Process, err)
+		}
+	})
+	if err != nil {
+		fmt.Println("Error listing audio sessions", err)
+	}
+}
+
+func (d *Ducker) isDucked(processName string) bool {
+	for _, app := range d.Apps {
+		if app == processName {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Ducker) duckDevice() {
+	aev, deviceName, release, err := GetDefaultRenderDevice()
+	if err != nil {
+		fmt.Println("Error getting default output device", err)
+		return
+	}
+	defer release()
+	var original float32
+	UpdateState(func(state *SavedState) {
+		if saved, ok := state.RenderVolumes[deviceName]; ok {
+			original = saved
+		} else {
+			original = GetVolume(aev)
+			state.RenderVolumes[deviceName] = original
+		}
+	})
+	if err := SetVolume(aev, original*d.Factor); err != nil {
+		fmt.Println("Error ducking output device:", deviceName, err)
+	}
+}
+
+// RestoreDucking puts back every output device and session Muteiny ducked in this session
+func RestoreDucking() {
+	restoreDucking(false)
+}
+
+// RestoreSavedDucking puts back the output devices and sessions left ducked by a session that didn't shut down cleanly
+func RestoreSavedDucking() {
+	restoreDucking(true)
+}
+
+// restoreDucking puts back the ducked output devices and sessions. afterRestart matches the sessions the
+// instance identifier doesn't find by process name, the application may have been restarted since.
+func restoreDucking(afterRestart bool) {
+	var volumes map[string]float32
+	var sessions map[string]SessionVolume
+	ReadState(func(state *SavedState) {
+		volumes = make(map[string]float32, len(state.RenderVolumes))
+		for deviceName, level := range state.RenderVolumes {
+			volumes[deviceName] = level
+		}
+		sessions = make(map[string]SessionVolume, len(state.SessionVolumes))
+		for id, saved := range state.SessionVolumes {
+			sessions[id] = saved
+		}
+	})
+
+	if len(volumes) > 0 {
+		devices, releaseAll := GetAllRenderDevices()
+		defer releaseAll()
+		for deviceName, level := range volumes {
+			device := devices[deviceName]
+			if device == nil {
+				//? Keep it for the next launch, the device may just be unplugged
+				fmt.Println("Output device not found:", deviceName)
+				continue
+			}
+			if err := SetVolume(device, level); err != nil {
+				fmt.Println("Error restoring volume for:", deviceName, err)
+				continue
+			}
+			UpdateState(func(state *SavedState) {
+				delete(state.RenderVolumes, deviceName)
+			})
+		}
+	}
+
+	if len(sessions) > 0 {
+		byProcess := make(map[string]float32)
+		if afterRestart {
+			for _, saved := range sessions {
+				if _, ok := byProcess[saved.Process]; !ok {
+					byProcess[saved.Process] = saved.Level
+				}
+			}
+		}
+		err := forEachRenderSession(func(session renderSession, sav *wca.ISimpleAudioVolume) {
+			level, ok := byProcess[session.Process]
+			if saved, found := sessions[session.ID]; found {
+				level, ok = saved.Level, true
+			}
+			if !ok {
+				return
+			}
+			if err := sav.SetMasterVolume(level, muteinyEventContext); err != nil {
+				fmt.Println("Error restoring volume for:", session.Process, err)
+			}
+		})
+		if err != nil {
+			fmt.Println("Error listing audio sessions", err)
+			return
+		}
+		//? A session that is gone has nothing left to restore
+		UpdateState(func(state *SavedState) {
+			for id := range sessions {
+				delete(state.SessionVolumes, id)
+			}
+		})
+	}
+}
+
+// renderSession identifies an audio session of an application
+type renderSession struct {
+	ID      string // Session instance identifier, unique to the session
+	Process string // Lower case executable name, like game.exe
+}
+
+// forEachRenderSession calls fn with the volume of every audio session on the default output device
+func forEachRenderSession(fn func(session renderSession, sav *wca.ISimpleAudioVolume)) error {
+	var mmde *wca.IMMDeviceEnumerator
+	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
+		return err
+	}
+	defer mmde.Release()
+
+	var mmd *wca.IMMDevice
+	if err := mmde.GetDefaultAudioEndpoint(wca.ERender, wca.DEVICE_STATE_ACTIVE, &mmd); err != nil {
+		return err
+	}
+	defer mmd.Release()
+
+	var asm *wca.IAudioSessionManager2
+	if err := mmd.Activate(wca.IID_IAudioSessionManager2, wca.CLSCTX_ALL, nil, &asm); err != nil {
+		return err
+	}
+	defer asm.Release()
+
+	var ase *wca.IAudioSessionEnumerator
+	if err := asm.GetSessionEnumerator(&ase); err != nil {
+		return err
+	}
+	defer ase.Release()
+
+	var count int
+	if err := ase.GetCount(&count); err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		var asc *wca.IAudioSessionControl
+		if err := ase.GetSession(i, &asc); err != nil {
+			return err
+		}
+		func() {
+			defer asc.Release()
+			var asc2 *wca.IAudioSessionControl2
+			if err := asc.PutQueryInterface(wca.IID_IAudioSessionControl2, &asc2); err != nil {
+				return
+			}
+			defer asc2.Release()
+			var pid uint32
+			if err := asc2.GetProcessId(&pid); err != nil || pid == 0 { //? pid 0 is the system sounds session
+				return
+			}
+			name, err := processName(pid)
+			if err != nil {
+				return
+			}
+			id, err := sessionInstanceIdentifier(asc2)
+			if err != nil {
+				return
+			}
+			var sav *wca.ISimpleAudioVolume
+			if err := asc.PutQueryInterface(wca.IID_ISimpleAudioVolume, &sav); err != nil {
+				return
+			}
+			defer sav.Release()
+			fn(renderSession{ID: id, Process: name}, sav)
+		}()
+	}
+	return nil
+}
+
+// sessionInstanceIdentifier calls IAudioSessionControl2::GetSessionInstanceIdentifier,
+// go-wca reads the returned string pointer into 32 bits which breaks on 64 bit Windows
+func sessionInstanceIdentifier(asc2 *wca.IAudioSessionControl2) (string, error) {
+	var id *uint16
+	hr, _, _ := syscall.SyscallN(
+		asc2.VTable().GetSessionInstanceIdentifier,
+		uintptr(unsafe.Pointer(asc2)),
+		uintptr(unsafe.Pointer(&id)))
+	if hr != 0 {
+		return "", ole.NewError(hr)
+	}
+	defer ole.CoTaskMemFree(uintptr(unsafe.Pointer(id)))
+	return windows.UTF16PtrToString(id), nil
+}
+
+// processName returns the lower case executable name of a process, like game.exe
+func processName(pid uint32) (string, error) {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(handle)
+	buf := make([]uint16, windows.MAX_PATH)
+	size := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(handle, 0, &buf[0], &size); err != nil {
+		return "", err
+	}
+	return strings.ToLower(filepath.Base(windows.UTF16ToString(buf[:size]))), nil
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"Muteiny/level"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/getlantern/systray"
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+// Engine keeps track of the push-to-talk state so it can be applied to whatever device is the default capture device
+type Engine struct {
+	mu     sync.Mutex
+	device string // Name of the capture device under push-to-talk control, it follows the default device
+	open   bool   // The state applied to the device
+	ptt    bool   // True while a binding is held, in voice mode it forces the mic open or muted depending on -voiceptt
+	remote bool   // True when ptt was set by the control API or the startup policy, not by a key the reconciler can check
+	voice  bool   // True while the voice gate is open
+
+	deafened bool // True while the deafen binding mutes the output and the mic
+	panicked bool // True after the panic binding muted every capture device, until re-armed
+
+	watchdog     *time.Timer // Force closes the mic after -maxopen
+	forcedCloses int         // How often the watchdog closed the mic
+
+	releaseCancel chan struct{} // Closed to cancel the pending release
+
+	watchMu       sync.Mutex
+	stopMuteWatch func() // Stops watching the mute state of the current default device
+}
+
+var engine = &Engine{}
+
+// IsOpen returns the current push-to-talk state
+func (e *Engine) IsOpen() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.open
+}
+
+// DeviceName returns the name of the capture device under push-to-talk control
+func (e *Engine) DeviceName() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.device
+}
+
+// InitDevice takes control of the default device found at startup, its snapshot is already taken
+func (e *Engine) InitDevice(deviceName string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.device = deviceName
+	fmt.Printf("Input Device: %s\n", deviceName)
+	MarkDeviceUsed(deviceName)
+	SetDefaultDeviceName(deviceName)
+}
+
+// IsHeld returns true while the engine thinks a key binding is held, false once its release is pending.
+// A remote hold is not a key binding, there is no key the reconciler could find up.
+func (e *Engine) IsHeld() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.ptt && !e.remote && e.releaseCancel == nil
+}
+
+// SetOpen opens or closes the mic right away from the control API, skipping the hold time
+func (e *Engine) SetOpen(open bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cancelRelease()
+	e.ptt = open
+	e.remote = true
+	e.apply()
+}
+
+// Toggle flips the mic state from the control API, reading and setting it under one lock so a press in between can't reverse it
+func (e *Engine) Toggle() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cancelRelease()
+	e.ptt = !e.open
+	e.remote = true
+	e.apply()
+}
+
+// Press is called when a binding goes down, it opens the mic and cancels a pending release
+func (e *Engine) Press() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cancelRelease()
+	e.ptt = true
+	e.remote = false
+	e.apply()
+}
+
+// PressRemote is Press from the control API, the hold lasts until a release whatever the keys are doing
+func (e *Engine) PressRemote() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cancelRelease()
+	e.ptt = true
+	e.remote = true
+	e.apply()
+}
+
+// Release is called when a binding goes up, it closes the mic after the hold time
+// or, with -silencerelease, once the input has gone silent with the hold time as a cap
+func (e *Engine) Release() {
+	e.mu.Lock()
+	e.cancelRelease()
+	cancel := make(chan struct{})
+	e.releaseCancel = cancel
+	e.mu.Unlock()
+
+	// ? We run this goroutine because otherwise we lock the input thread causing lag due to the wait
+	go func() {
+		if !waitForRelease(cancel) {
+			return
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		//? A press may have come in while we were waiting for the lock
+		select {
+		case <-cancel:
+			return
+		default:
+		}
+		e.releaseCancel = nil
+		e.ptt = false
+		e.remote = false
+		e.apply()
+	}()
+}
+
+// cancelRelease stops a pending release, e.mu must be held
+func (e *Engine) cancelRelease() {
+	if e.releaseCancel != nil {
+		close(e.releaseCancel)
+		e.releaseCancel = nil
+	}
+}
+
+// waitForRelease waits until the mic should close, returns false if it was cancelled
+func waitForRelease(cancel chan struct{}) bool {
+	hold := time.NewTimer(time.Duration(holdFlag.Value) * time.Millisecond)
+	defer hold.Stop()
+	if !silenceReleaseFlag {
+		select {
+		case <-cancel:
+			return false
+		case <-hold.C:
+			return true
+		}
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	InitOLE()
+	defer ole.CoUninitialize()
+	meter := &DefaultMeter{}
+	defer meter.Close()
+
+	silence := &level.SilenceDetector{
+		Threshold: float32(silenceThresholdFlag.Value),
+		Duration:  time.Duration(silenceTimeFlag.Value) * time.Millisecond,
+	}
+	ticker := time.NewTicker(meterInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cancel:
+			return false
+		case <-hold.C:
+			return true
+		case now := <-ticker.C:
+			level, err := meter.Peak()
+			if err != nil {
+				//? Without a meter we can only wait for the cap
+				fmt.Println("Error reading peak meter", err)
+				select {
+				case <-cancel:
+					return false
+				case <-hold.C:
+					return true
+				}
+			}
+			if silence.Update(level, now) {
+				return true
+			}
+		}
+	}
+}
+
+// SetVoice is called by the voice gate when it opens or closes
+func (e *Engine) SetVoice(open bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.voice = open
+	e.apply()
+}
+
+// apply works out the state from the bindings and the voice gate and sets it on the device, e.mu must be held
+func (e *Engine) apply() {
+	open := e.ptt
+	if voiceFlag {
+		if voicePTTFlag.Value == "mute" {
+			open = e.voice && !e.ptt
+		} else {
+			open = e.voice || e.ptt
+		}
+	}
+	if e.deafened || e.panicked {
+		open = false
+	}
+	// We run this every time to make sure we have the correct device
+	aev, deviceName, release := GetDefaultDevice()
+	e.useDevice(deviceName, aev)
+	SetMuteThread(deviceName, aev, !open)
+	release()
+	e.setState(open)
+}
+
+// setState records the mic state and tells the subscribers when it changed, e.mu must be held
+func (e *Engine) setState(open bool) {
+	if open == e.open {
+		return
+	}
+	e.open = open
+	eventType := "close"
+	if open {
+		eventType = "open"
+		e.startWatchdog()
+	} else {
+		e.stopWatchdog()
+	}
+	Publish(Event{Type: eventType, Open: open, Deafened: e.deafened, Device: e.device})
+}
+
+// RunVoiceActivation opens the mic while the voice gate on the default device's peak meter is open, returns a function to stop it
+func (e *Engine) RunVoiceActivation() func() {
+	gate := &level.VoiceGate{
+		Attack:      float32(voiceAttackFlag.Value),
+		Release:     float32(voiceReleaseFlag.Value),
+		ReleaseTime: time.Duration(voiceReleaseTimeFlag.Value) * time.Millisecond,
+	}
+	return runWithMeter(func(meter level.Meter, stop <-chan struct{}) {
+		level.RunVoiceGate(meter, gate, meterInterval, stop, func(open bool) {
+			fmt.Println("Voice gate open:", open)
+			e.SetVoice(open)
+		})
+	})
+}
+
+// RunMutedWarning warns when the default device's peak meter shows speech while the mic is muted, returns a function to stop it
+func (e *Engine) RunMutedWarning() func() {
+	detector := &level.MutedTalkDetector{
+		Threshold: float32(mutedWarningThresholdFlag.Value),
+		Sustain:   time.Duration(mutedWarningSustainFlag.Value) * time.Millisecond,
+		Gap:       300 * time.Millisecond,
+		Cooldown:  time.Duration(mutedWarningCooldownFlag.Value) * time.Second,
+	}
+	return runWithMeter(func(meter level.Meter, stop <-chan struct{}) {
+		level.RunMutedTalkDetector(meter, detector, meterInterval, stop, func() bool { return !e.IsOpen() }, WarnTalkingWhileMuted)
+	})
+}
+
+// runWithMeter runs fn on its own OLE initialized thread with the meter of the default device, returns a function to stop it
+func runWithMeter(fn func(meter level.Meter, stop <-chan struct{})) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		InitOLE()
+		defer ole.CoUninitialize()
+
+		meter := &DefaultMeter{}
+		defer meter.Close()
+		fn(meter, stop)
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// DefaultDeviceChanged moves the push-to-talk state over to the new default capture device
+// and restores the previous device to the mute state it had when Muteiny started.
+func (e *Engine) DefaultDeviceChanged() {
+	e.mu.Lock()
+	aev, deviceName, release := GetDefaultDevice()
+	e.useDevice(deviceName, aev)
+	release()
+	e.mu.Unlock()
+	//? The mute watcher is bound to the old device, restart it outside of the lock as it calls into the engine.
+	//? It is restarted even when nothing moved here, apply may have noticed the new device first.
+	e.WatchMute()
+}
+
+// useDevice moves push-to-talk control to deviceName when it is not the device under control, e.mu must be held.
+// Whoever sees the new default device first does the move, so the old device is always restored.
+func (e *Engine) useDevice(deviceName string, aev *wca.IAudioEndpointVolume) {
+	if deviceName == e.device {
+		return
+	}
+	oldDeviceName := e.device
+	e.device = deviceName
+	fmt.Printf("Default input device changed from %s to %s\n", oldDeviceName, deviceName)
+	MarkDeviceUsed(deviceName)
+	SetDefaultDeviceName(deviceName)
+
+	//? A device plugged in after startup has no snapshot yet, take it before we touch it
+	if _, ok := OriginalMute(deviceName); !ok {
+		RememberOriginalMute(deviceName, GetMute(aev))
+	}
+	SetMuteThread(deviceName, aev, !e.open)
+	Publish(Event{Type: "device", Open: e.open, Deafened: e.deafened, Device: deviceName})
+
+	muteState, ok := OriginalMute(oldDeviceName)
+	if !ok {
+		return
+	}
+	devices, releaseAll := GetAllDevices()
+	defer releaseAll()
+	if device := devices[oldDeviceName]; device != nil {
+		//? Volume based modes left the old device at level 0, give it its level back
+		if err := muteStrategy.Restore(oldDeviceName, device); err != nil {
+			fmt.Println("Error restoring:", oldDeviceName, err)
+		}
+		RestoreGain(oldDeviceName, device)
+		fmt.Println("Restoring mute state for:", oldDeviceName, "to:", muteState)
+		if muteState != GetMute(device) {
+			if err := SetMute(device, muteState); err != nil {
+				fmt.Println("Error setting mute state for:", oldDeviceName, err)
+			}
+		}
+	} else {
+		fmt.Println("Device not found:", oldDeviceName)
+	}
+}
+
+// WatchMute starts watching the current default capture device for mute changes, replacing any previous watcher
+func (e *Engine) WatchMute() {
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+	if e.stopMuteWatch != nil {
+		e.stopMuteWatch()
+	}
+	e.stopMuteWatch = WatchMute(e.MuteChanged)
+}
+
+// StopWatchingMute stops the mute watcher
+func (e *Engine) StopWatchingMute() {
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+	if e.stopMuteWatch != nil {
+		e.stopMuteWatch()
+		e.stopMuteWatch = nil
+	}
+}
+
+// MuteChanged keeps the tray icon truthful and applies the -externalmute policy
+// when something other than Muteiny changes the mute state of the default device.
+func (e *Engine) MuteChanged(aev *wca.IAudioEndpointVolume, deviceName string, muted bool, volume float32, external bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	//? A watcher still bound to the old device, it is restarted once the move is handled
+	if deviceName != e.device {
+		return
+	}
+
+	//? Nothing may unmute the mic until it is re-armed, whatever -externalmute says
+	if e.panicked {
+		if external && !muted {
+			fmt.Println("Mic was unmuted by another program while panicked, muting it again")
+			if err := SetMute(aev, true); err != nil {
+				fmt.Println("Error muting", err)
+			}
+		}
+		return
+	}
+
+	//? In volume mode a capture level of 0 is what counts as muted
+	muted = muteStrategy.NotifiedMuted(muted, volume)
+
+	SetTrayIcon(muted)
+	if muted == !e.open || !external {
+		if systrayActive {
+			systray.SetTooltip("Muteiny")
+		}
+		return
+	}
+
+	switch externalMuteFlag.Value {
+	case "reassert":
+		fmt.Println("Mute state changed outside of Muteiny, setting it back to:", !e.open)
+		if err := muteStrategy.SetMuted(e.device, aev, !e.open); err != nil {
+			fmt.Println("Error reasserting mute state", err)
+		}
+	case "adopt":
+		fmt.Println("Mute state changed outside of Muteiny, adopting:", muted)
+		e.setState(!muted)
+	default:
+		fmt.Println("Warning: mute state changed outside of Muteiny to:", muted)
+		if systrayActive {
+			if muted {
+				systray.SetTooltip("Muteiny: mic was muted by another program")
+			} else {
+				systray.SetTooltip("Muteiny: mic was unmuted by another program, you may be live!")
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is sent to the subscribers of the engine when something changes
+type Event struct {
+	Type     string    `json:"type"`              // "open", "close", "deafen", "panic", "rearm", "watchdog", "device" or "profile"
+	Open     bool      `json:"open"`              // The mic state after the event
+	Deafened bool      `json:"deafened"`          // The deafen state after the event
+	Device   string    `json:"device"`            // The default capture device
+	Profile  string    `json:"profile,omitempty"` // The new profile of a "profile" event
+	Time     time.Time `json:"time"`
+}
+
+// Events that are waiting to be delivered to a slow subscriber before new ones are dropped
+const subscriberBuffer = 64
+
+var (
+	subscribersMutex sync.Mutex
+	subscribers      []chan Event
+)
+
+// Subscribe calls fn for every event, in order, on its own goroutine so a slow subscriber never blocks the engine
+func Subscribe(fn func(event Event)) {
+	events := make(chan Event, subscriberBuffer)
+	subscribersMutex.Lock()
+	subscribers = append(subscribers, events)
+	subscribersMutex.Unlock()
+	go func() {
+		for event := range events {
+			fn(event)
+		}
+	}()
+}
+
+// Publish sends the event to every subscriber
+func Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+	for _, events := range subscribers {
+		select {
+		case events <- event:
+		default:
+			//? Dropping is better than holding up the mic
+		}
+	}
+}
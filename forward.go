@@ -0,0 +1,69 @@
+package main
+
+import (
+	"Muteiny/ipc"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+var procAttachConsole = windows.NewLazySystemDLL("kernel32.dll").NewProc("AttachConsole")
+
+// ForwardCommand sends a command like `muteiny toggle` to the running instance and prints the result, returns the exit code
+func ForwardCommand(name string, args []string) int {
+	attachParentConsole()
+
+	method := args[0]
+	var params interface{}
+	switch method {
+	case "set-profile":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: muteiny set-profile <name>")
+			return 2
+		}
+		params = map[string]string{"name": args[1]}
+	default:
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "usage: muteiny %s\n", method)
+			return 2
+		}
+	}
+
+	conn, err := ipc.Dial(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Muteiny is not running:", err)
+		return 1
+	}
+	client := ipc.NewClient(conn)
+	defer client.Close()
+
+	var result json.RawMessage
+	if err := client.Call(method, params, &result); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	output, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		output = result
+	}
+	fmt.Println(string(output))
+	return 0
+}
+
+// attachParentConsole lets the windowless build print to the console it was started from.
+// Output that is already redirected, to a file or a pipe, is left alone.
+func attachParentConsole() {
+	const ATTACH_PARENT_PROCESS = ^uintptr(0) // (DWORD)-1
+	if handle, err := windows.GetStdHandle(windows.STD_OUTPUT_HANDLE); err == nil && handle != 0 && handle != windows.InvalidHandle {
+		return
+	}
+	if res, _, _ := procAttachConsole.Call(ATTACH_PARENT_PROCESS); res == 0 {
+		return
+	}
+	if console, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0); err == nil {
+		os.Stdout = console
+		os.Stderr = console
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+// GainPreset is the capture level set when Muteiny opens the mic
+type GainPreset struct {
+	Level        string `json:"level"`                  // Scalar from 0 to 1 like 0.8, or decibels like -6dB
+	ResetOnClose bool   `json:"resetOnClose,omitempty"` // Put the original level back when the mic closes
+}
+
+// GainLevel is a parsed GainPreset level
+type GainLevel struct {
+	Value float32
+	DB    bool // Value is in decibels instead of a scalar
+}
+
+// ParseGainLevel parses a scalar like 0.8 or a decibel value like -6dB
+func ParseGainLevel(value string) (GainLevel, error) {
+	value = strings.TrimSpace(value)
+	lower := strings.ToLower(value)
+	if strings.HasSuffix(lower, "db") {
+		db, err := strconv.ParseFloat(strings.TrimSpace(lower[:len(lower)-2]), 32)
+		if err != nil {
+			return GainLevel{}, fmt.Errorf("invalid gain %q", value)
+		}
+		//? The range depends on the device, many have a boost above 0dB, ApplyGain checks it
+		if math.IsNaN(db) || math.IsInf(db, 0) {
+			return GainLevel{}, fmt.Errorf("invalid gain %q", value)
+		}
+		return GainLevel{Value: float32(db), DB: true}, nil
+	}
+	scalar, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return GainLevel{}, fmt.Errorf("invalid gain %q, use a scalar like 0.8 or decibels like -6dB", value)
+	}
+	if !(scalar >= 0 && scalar <= 1) {
+		return GainLevel{}, fmt.Errorf("gain %q must be between 0 and 1", value)
+	}
+	return GainLevel{Value: float32(scalar)}, nil
+}
+
+func (p *GainPreset) validate() error {
+	if p == nil {
+		return errors.New("gain preset is empty")
+	}
+	_, err := ParseGainLevel(p.Level)
+	return err
+}
+
+// GainPresetFor returns the preset for a device, nil if its level should be left alone.
+// -gain applies to every device, else the profile preset for the device or its "*" preset is used.
+func GainPresetFor(deviceName string) *GainPreset {
+	if gainFlag.IsSet {
+		return &GainPreset{Level: gainFlag.Value, ResetOnClose: gainResetFlag}
+	}
+	_, profile := ActiveProfile()
+	if preset, ok := profile.Gain[deviceName]; ok {
+		return preset
+	}
+	return profile.Gain["*"]
+}
+
+// CheckGainMode refuses a profile with gain presets, or -gain, under a volume based -mutemode.
+// Those modes silence the mic through the capture level, a preset would fight them.
+func CheckGainMode(profile *Profile) error {
+	if muteModeFlag.Value != "mute" && (gainFlag.IsSet || len(profile.Gain) > 0) {
+		return errors.New("gain presets only work with -mutemode mute")
+	}
+	return nil
+}
+
+// ApplyGain sets the preset level of the device, remembering the original level for shutdown
+func ApplyGain(deviceName string, aev *wca.IAudioEndpointVolume) {
+	preset := GainPresetFor(deviceName)
+	if preset == nil {
+		return
+	}
+	level, err := ParseGainLevel(preset.Level)
+	if err != nil {
+		fmt.Println("Error in gain preset for:", deviceName, err)
+		return
+	}
+	original := GetVolume(aev)
+	UpdateState(func(state *SavedState) {
+		if _, ok := state.GainVolumes[deviceName]; !ok {
+			state.GainVolumes[deviceName] = original
+		}
+	})
+	fmt.Println("Setting gain for:", deviceName, "to:", preset.Level)
+	if level.DB {
+		var minDB, maxDB, incrementDB float32
+		if err := aev.GetVolumeRange(&minDB, &maxDB, &incrementDB); err != nil {
+			fmt.Println("Error reading the volume range of:", deviceName, err)
+		} else if level.Value < minDB || level.Value > maxDB {
+			clamped := level.Value
+			if clamped < minDB {
+				clamped = minDB
+			} else {
+				clamped = maxDB
+			}
+			fmt.Printf("Gain %s is outside the range of %s, %gdB to %gdB, using %gdB\n", preset.Level, deviceName, minDB, maxDB, clamped)
+			level.Value = clamped
+		}
+		err = aev.SetMasterVolumeLevel(level.Value, muteinyEventContext)
+	} else {
+		err = SetVolume(aev, level.Value)
+	}
+	if err != nil {
+		fmt.Println("Error setting gain for:", deviceName, err)
+	}
+}
+
+// ResetGain puts the original level back when the mic closes, if the preset asks for it
+func ResetGain(deviceName string, aev *wca.IAudioEndpointVolume) {
+	if preset := GainPresetFor(deviceName); preset != nil && preset.ResetOnClose {
+		RestoreGain(deviceName, aev)
+	}
+}
+
+// RestoreGain puts back the level the device had before a gain preset was applied
+func RestoreGain(deviceName string, aev *wca.IAudioEndpointVolume) {
+	var level float32
+	var ok bool
+	ReadState(func(state *SavedState) {
+		level, ok = state.GainVolumes[deviceName]
+	})
+	if !ok {
+		return
+	}
+	fmt.Println("Restoring gain for:", deviceName, "to:", level)
+	if err := SetVolume(aev, level); err != nil {
+		fmt.Println("Error restoring gain for:", deviceName, err)
+		return
+	}
+	UpdateState(func(state *SavedState) {
+		delete(state.GainVolumes, deviceName)
+	})
+}
+
+// RestoreAllGain restores every capture device a gain preset was applied to, including those left by a previous session
+func RestoreAllGain() {
+	var deviceNames []string
+	ReadState(func(state *SavedState) {
+		for deviceName := range state.GainVolumes {
+			deviceNames = append(deviceNames, deviceName)
+		}
+	})
+	if len(deviceNames) == 0 {
+		return
+	}
+	devices, releaseAll := GetAllDevices()
+	defer releaseAll()
+	for _, deviceName := range deviceNames {
+		if device := devices[deviceName]; device != nil {
+			RestoreGain(deviceName, device)
+		} else {
+			//? Keep it for the next launch, the device may just be unplugged
+			fmt.Println("Device not found:", deviceName)
+		}
+	}
+}
@@ -7,6 +7,7 @@ require (
 	github.com/go-ole/go-ole v1.2.6
 	github.com/moutend/go-hook v0.1.0
 	github.com/moutend/go-wca v0.2.0
+	golang.org/x/sys v0.15.0
 )
 
 require (
@@ -18,5 +19,4 @@ require (
 	github.com/getlantern/ops v0.0.0-20190325191751-d70cb0d6f85f // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c // indirect
-	golang.org/x/sys v0.15.0 // indirect
 )
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/getlantern/systray"
+	"github.com/moutend/go-hook/pkg/types"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procSetWindowsHookEx    = user32.NewProc("SetWindowsHookExW")
+	procUnhookWindowsHookEx = user32.NewProc("UnhookWindowsHookEx")
+	procCallNextHookEx      = user32.NewProc("CallNextHookEx")
+	procGetMessage          = user32.NewProc("GetMessageW")
+	procTranslateMessage    = user32.NewProc("TranslateMessage")
+	procDispatchMessage     = user32.NewProc("DispatchMessageW")
+	procPostThreadMessage   = user32.NewProc("PostThreadMessageW")
+)
+
+// Events buffered between a hook and its consumer, the hook must never wait or Windows removes it
+const hookBuffer = 64
+
+// MSG
+type hookMsg struct {
+	Hwnd    uintptr
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	Pt      types.POINT
+	Private uint32
+}
+
+// Where the hooks deliver their events, swapped on every install.
+// The hook procedures are created once because Windows callbacks are never freed.
+var (
+	hookSinkMutex sync.Mutex
+	keyboardSink  chan<- types.KeyboardEvent
+	keyboardAlive func()
+	mouseSink     chan<- types.MouseEvent
+	mouseAlive    func()
+
+	keyboardCallback = syscall.NewCallback(keyboardHookProc)
+	mouseCallback    = syscall.NewCallback(mouseHookProc)
+)
+
+func keyboardHookProc(code int32, wParam uintptr, lParam *types.KBDLLHOOKSTRUCT) uintptr {
+	if code >= 0 && lParam != nil {
+		hookSinkMutex.Lock()
+		events, alive := keyboardSink, keyboardAlive
+		hookSinkMutex.Unlock()
+		if alive != nil {
+			alive()
+		}
+		if events != nil {
+			select {
+			case events <- types.KeyboardEvent{Message: types.Message(wParam), KBDLLHOOKSTRUCT: *lParam}:
+			default:
+				//? Dropping an event is better than losing the hook, the reconciler and watchdog catch a lost release
+			}
+		}
+	}
+	res, _, _ := procCallNextHookEx.Call(0, uintptr(code), wParam, uintptr(unsafe.Pointer(lParam)))
+	return res
+}
+
+func mouseHookProc(code int32, wParam uintptr, lParam *types.MSLLHOOKSTRUCT) uintptr {
+	if code >= 0 && lParam != nil {
+		hookSinkMutex.Lock()
+		events, alive := mouseSink, mouseAlive
+		hookSinkMutex.Unlock()
+		if alive != nil {
+			alive()
+		}
+		if events != nil {
+			select {
+			case events <- types.MouseEvent{Message: types.Message(wParam), MSLLHOOKSTRUCT: *lParam}:
+			default:
+			}
+		}
+	}
+	res, _, _ := procCallNextHookEx.Call(0, uintptr(code), wParam, uintptr(unsafe.Pointer(lParam)))
+	return res
+}
+
+// installKeyboardHook installs a low level keyboard hook that sends to events, the returned function removes it
+func installKeyboardHook(events chan<- types.KeyboardEvent, alive func()) (func(), error) {
+	hookSinkMutex.Lock()
+	keyboardSink, keyboardAlive = events, alive
+	hookSinkMutex.Unlock()
+	uninstall, err := installHook(types.WH_KEYBOARD_LL, keyboardCallback)
+	if err != nil {
+		return nil, fmt.Errorf("installing keyboard hook: %w", err)
+	}
+	return func() {
+		uninstall()
+		hookSinkMutex.Lock()
+		keyboardSink, keyboardAlive = nil, nil
+		hookSinkMutex.Unlock()
+	}, nil
+}
+
+// installMouseHook installs a low level mouse hook that sends to events, the returned function removes it
+func installMouseHook(events chan<- types.MouseEvent, alive func()) (func(), error) {
+	hookSinkMutex.Lock()
+	mouseSink, mouseAlive = events, alive
+	hookSinkMutex.Unlock()
+	uninstall, err := installHook(types.WH_MOUSE_LL, mouseCallback)
+	if err != nil {
+		return nil, fmt.Errorf("installing mouse hook: %w", err)
+	}
+	return func() {
+		uninstall()
+		hookSinkMutex.Lock()
+		mouseSink, mouseAlive = nil, nil
+		hookSinkMutex.Unlock()
+	}, nil
+}
+
+// installHook installs a hook on its own thread, which runs the message loop the hook needs.
+// The returned function removes the hook and ends the thread.
+func installHook(idHook types.Hook, callback uintptr) (func(), error) {
+	const WM_QUIT = 0x0012
+	installed := make(chan error, 1)
+	var threadID uint32
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		hhk, _, err := procSetWindowsHookEx.Call(uintptr(idHook), callback, 0, 0)
+		if hhk == 0 {
+			installed <- err
+			return
+		}
+		defer procUnhookWindowsHookEx.Call(hhk)
+		threadID = windows.GetCurrentThreadId()
+		installed <- nil
+
+		var msg hookMsg
+		for {
+			//? 0 is WM_QUIT, -1 an error, both end the loop
+			res, _, _ := procGetMessage.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+			if int32(res) <= 0 {
+				return
+			}
+			procTranslateMessage.Call(uintptr(unsafe.Pointer(&msg)))
+			procDispatchMessage.Call(uintptr(unsafe.Pointer(&msg)))
+		}
+	}()
+	if err := <-installed; err != nil {
+		if err == windows.ERROR_SUCCESS {
+			err = errors.New("SetWindowsHookEx failed")
+		}
+		return nil, err
+	}
+	return func() {
+		procPostThreadMessage.Call(uintptr(threadID), WM_QUIT, 0, 0)
+	}, nil
+}
+
+// Virtual key codes of the mouse buttons, the keyboard heartbeat watches every other key
+var mouseButtonKeys = []int{0x01, 0x02, 0x04, 0x05, 0x06}
+
+// keyboardKeys returns every virtual key code that isn't a mouse button
+func keyboardKeys() []int {
+	keys := []int{}
+	for vk := 0x08; vk <= 0xFE; vk++ {
+		keys = append(keys, vk)
+	}
+	return keys
+}
+
+// Health of the input hooks shown in the tray
+var (
+	hookHealthMutex sync.Mutex
+	hookHealth      = make(map[string]string)
+	hookHealthMenus = make(map[string]*systray.MenuItem)
+)
+
+// reportHookHealth is called by the supervisors with the status of a hook
+func reportHookHealth(name, status string) {
+	hookHealthMutex.Lock()
+	defer hookHealthMutex.Unlock()
+	previous := hookHealth[name]
+	hookHealth[name] = status
+	if menu := hookHealthMenus[name]; menu != nil {
+		menu.SetTitle(name + ": " + status)
+	}
+	if !systrayActive {
+		return
+	}
+	if status != "ok" {
+		systray.SetTooltip("Muteiny: " + name + " " + status)
+	} else if previous != "ok" && previous != "starting" {
+		systray.SetTooltip("Muteiny")
+	}
+}
+
+// addHookHealthMenus adds a tray item per supervised hook
+func addHookHealthMenus() {
+	hookHealthMutex.Lock()
+	defer hookHealthMutex.Unlock()
+	names := []string{}
+	for name := range hookHealth {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hookHealthMenus[name] = systray.AddMenuItem(name+": "+hookHealth[name], "Health of the input hook")
+	}
+}
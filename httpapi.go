@@ -0,0 +1,29 @@
+package main
+
+import (
+	"Muteiny/httpapi"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RunStatusAPI serves the status API on localhost until the returned function is called
+func RunStatusAPI(port int, token string, origins string) (func(), error) {
+	api := httpapi.New(NewControlServer(), token, origins)
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	Subscribe(func(event Event) { api.Broadcast(event) })
+	server := &http.Server{Handler: api, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	fmt.Println("Status API listening on http://" + listener.Addr().String())
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+		api.Close()
+	}, nil
+}
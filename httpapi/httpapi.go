@@ -0,0 +1,269 @@
+// Package httpapi is the localhost HTTP and websocket status API for overlays and dashboards.
+// It runs the methods of the control API and refuses web pages that weren't allowed to use it.
+package httpapi
+
+import (
+	"Muteiny/ipc"
+	"Muteiny/websocket"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Events buffered for a websocket client before it is dropped as too slow
+const clientBuffer = 32
+
+// Largest POST body read
+const maxBodySize = 64 * 1024
+
+// REST endpoints and the control method they run, GET reads and POST commands
+var endpoints = map[string]struct {
+	httpMethod string
+	method     string
+}{
+	"/api/status":  {http.MethodGet, "status"},
+	"/api/devices": {http.MethodGet, "list-devices"},
+	"/api/mute":    {http.MethodPost, "mute"},
+	"/api/unmute":  {http.MethodPost, "unmute"},
+	"/api/toggle":  {http.MethodPost, "toggle"},
+	"/api/press":   {http.MethodPost, "press"},
+	"/api/release": {http.MethodPost, "release"},
+	"/api/profile": {http.MethodPost, "set-profile"},
+}
+
+// Websocket that pushes the status and then every event
+const eventsPath = "/api/events"
+
+// Server is the status API, an http.Handler
+type Server struct {
+	Token   string          // Required as a Bearer token or ?token= when set
+	Origins map[string]bool // Web page origins allowed besides our own, browsers on other pages are refused
+	control *ipc.Server
+
+	mu      sync.Mutex
+	clients map[chan []byte]*websocket.Conn // Event queue of every websocket client
+	closed  bool
+}
+
+// New creates the API over the control methods, origins is a comma separated list of the web page origins allowed to use it
+func New(control *ipc.Server, token string, origins string) *Server {
+	s := &Server{Token: token, Origins: make(map[string]bool), control: control, clients: make(map[chan []byte]*websocket.Conn)}
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			s.Origins[strings.ToLower(origin)] = true
+		}
+	}
+	return s
+}
+
+// Broadcast sends an event to every websocket client as JSON
+func (s *Server) Broadcast(event interface{}) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		select {
+		case client <- data:
+		default:
+			//? A client that can't keep up is dropped, it can reconnect and read the status again
+			delete(s.clients, client)
+			close(client)
+		}
+	}
+}
+
+// Close sends a close frame to every websocket client and refuses new ones.
+// http.Server.Shutdown doesn't close the websockets, their connections were taken over.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for client, conn := range s.clients {
+		conn.Close()
+		delete(s.clients, client)
+		close(client)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isLocalHost(r.Host) {
+		//? Stops DNS rebinding, a web page can't reach us through a name that resolves to 127.0.0.1
+		http.Error(w, "forbidden host", http.StatusForbidden)
+		return
+	}
+	//? Any web page the user opens can send a POST or open a websocket to localhost, only the allowed ones get through.
+	//? CORS alone wouldn't stop them, a simple POST runs before the browser looks at the response headers.
+	if !s.originAllowed(r) {
+		http.Error(w, "forbidden origin", http.StatusForbidden)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path == eventsPath {
+		s.serveEvents(w, r)
+		return
+	}
+	endpoint, ok := endpoints[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != endpoint.httpMethod {
+		w.Header().Set("Allow", endpoint.httpMethod)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var params json.RawMessage
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > 0 {
+			params = body
+		}
+	}
+	result, rpcErr := s.control.Call(endpoint.method, params)
+	w.Header().Set("Content-Type", "application/json")
+	if rpcErr != nil {
+		status := http.StatusInternalServerError
+		if rpcErr.Code == ipc.CodeInvalidParams {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": rpcErr.Message})
+		return
+	}
+	w.Write(result)
+}
+
+// authorized checks the token from the Authorization header or the token query parameter, browsers can't set headers on a websocket
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Token == "" {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// originAllowed checks the Origin header browsers send, requests from other programs have none.
+// Pages served by the API itself and the -httporigin list are allowed, "null" is a page opened from a file.
+func (s *Server) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if origin == "http://"+strings.ToLower(r.Host) {
+		return true
+	}
+	return s.Origins[origin]
+}
+
+// isLocalHost reports whether the Host header names the loopback interface
+func isLocalHost(hostPort string) bool {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = hostPort
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// statusMessage is the first websocket message, the result of the status method with "type": "status"
+func (s *Server) statusMessage() ([]byte, error) {
+	result, rpcErr := s.control.Call("status", nil)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	message := map[string]interface{}{}
+	if err := json.Unmarshal(result, &message); err != nil {
+		return nil, err
+	}
+	message["type"] = "status"
+	return json.Marshal(message)
+}
+
+// serveEvents pushes the status and then every event to a websocket
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	events := make(chan []byte, clientBuffer)
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.clients[events] = conn
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if _, ok := s.clients[events]; ok {
+			delete(s.clients, events)
+			close(events)
+		}
+		s.mu.Unlock()
+	}()
+
+	data, err := s.statusMessage()
+	if err != nil {
+		return
+	}
+	if err := conn.WriteText(data); err != nil {
+		return
+	}
+
+	//? Reading is what notices the client going away, and answers its pings
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-gone:
+			return
+		case data, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := conn.WriteText(data); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package httpapi
+
+import (
+	"Muteiny/ipc"
+	"Muteiny/websocket"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeControl stands in for the engine's control methods and records what ran
+type fakeControl struct {
+	mu    sync.Mutex
+	calls []string
+	open  bool
+}
+
+func (f *fakeControl) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *fakeControl) server() *ipc.Server {
+	server := ipc.NewServer()
+	status := func() interface{} {
+		return map[string]interface{}{"open": f.open, "device": "Microphone"}
+	}
+	record := func(method string, open bool) ipc.Handler {
+		return func(params json.RawMessage) (interface{}, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.calls = append(f.calls, method)
+			f.open = open
+			return status(), nil
+		}
+	}
+	server.Handle("status", func(params json.RawMessage) (interface{}, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return status(), nil
+	})
+	server.Handle("mute", record("mute", false))
+	server.Handle("unmute", record("unmute", true))
+	server.Handle("set-profile", func(params json.RawMessage) (interface{}, error) {
+		return nil, ipc.InvalidParams(errors.New("unknown profile"))
+	})
+	server.Handle("list-devices", func(params json.RawMessage) (interface{}, error) {
+		return nil, errors.New("no devices")
+	})
+	return server
+}
+
+func newAPI(token, origins string) (*Server, *fakeControl) {
+	control := &fakeControl{}
+	return New(control.server(), token, origins), control
+}
+
+// request runs a request against the API as if it came in on 127.0.0.1:8080
+func request(api *Server, method, target string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.Host = "127.0.0.1:8080"
+	for name, value := range headers {
+		if name == "Host" {
+			r.Host = value
+			continue
+		}
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	return w
+}
+
+func TestHostCheck(t *testing.T) {
+	api, _ := newAPI("", "")
+	for _, host := range []string{"127.0.0.1:8080", "localhost:8080", "LOCALHOST", "[::1]:8080", "127.0.0.2"} {
+		if w := request(api, http.MethodGet, "/api/status", map[string]string{"Host": host}); w.Code != http.StatusOK {
+			t.Errorf("host %s: status %d", host, w.Code)
+		}
+	}
+	//? A name that a DNS rebinding attack points at 127.0.0.1 is still refused
+	for _, host := range []string{"evil.example:8080", "192.168.1.10:8080", "localhost.evil.example", ""} {
+		if w := request(api, http.MethodGet, "/api/status", map[string]string{"Host": host}); w.Code != http.StatusForbidden {
+			t.Errorf("host %q: status %d, want 403", host, w.Code)
+		}
+	}
+}
+
+func TestOriginCheck(t *testing.T) {
+	api, control := newAPI("", "http://localhost:3000/, NULL")
+	allowed := []string{"http://127.0.0.1:8080", "http://localhost:3000", "HTTP://LOCALHOST:3000", "null"}
+	for _, origin := range allowed {
+		w := request(api, http.MethodGet, "/api/status", map[string]string{"Origin": origin})
+		if w.Code != http.StatusOK {
+			t.Errorf("origin %s: status %d", origin, w.Code)
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != origin {
+			t.Errorf("origin %s: Access-Control-Allow-Origin is %q", origin, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+	for _, origin := range []string{"https://evil.example", "http://localhost:3001", "http://127.0.0.1:8081"} {
+		w := request(api, http.MethodPost, "/api/unmute", map[string]string{"Origin": origin})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("origin %s: status %d, want 403", origin, w.Code)
+		}
+	}
+	if calls := control.Calls(); len(calls) != 0 {
+		t.Errorf("refused origins ran %v", calls)
+	}
+
+	if w := request(api, http.MethodGet, "/api/status", nil); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("CORS header sent without an Origin")
+	}
+	api, _ = newAPI("", "")
+	if w := request(api, http.MethodGet, "/api/status", map[string]string{"Origin": "null"}); w.Code != http.StatusForbidden {
+		t.Errorf("null origin without -httporigin: status %d, want 403", w.Code)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	api, control := newAPI("s3cret", "http://localhost:3000")
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		status  int
+	}{
+		{"no token", "/api/mute", nil, http.StatusUnauthorized},
+		{"wrong bearer", "/api/mute", map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized},
+		{"not bearer", "/api/mute", map[string]string{"Authorization": "Basic s3cret"}, http.StatusUnauthorized},
+		{"wrong query", "/api/mute?token=nope", nil, http.StatusUnauthorized},
+		{"bearer", "/api/mute", map[string]string{"Authorization": "Bearer s3cret"}, http.StatusOK},
+		{"query", "/api/mute?token=s3cret", nil, http.StatusOK},
+		{"header wins over query", "/api/mute?token=s3cret", map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		if w := request(api, http.MethodPost, test.target, test.headers); w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+	if calls := control.Calls(); len(calls) != 2 {
+		t.Errorf("ran %v, want the two authorized requests", calls)
+	}
+
+	//? A preflight carries no token, the request after it does
+	w := request(api, http.MethodOptions, "/api/mute", map[string]string{"Origin": "http://localhost:3000"})
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Headers") == "" {
+		t.Errorf("preflight: status %d, headers %v", w.Code, w.Header())
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	api, control := newAPI("", "")
+	w := request(api, http.MethodPost, "/api/unmute", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unmute: status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var status struct {
+		Open bool `json:"open"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || !status.Open {
+		t.Errorf("unmute answered %s", w.Body)
+	}
+	if calls := control.Calls(); len(calls) != 1 || calls[0] != "unmute" {
+		t.Errorf("ran %v", calls)
+	}
+
+	w = request(api, http.MethodGet, "/api/mute", nil)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET of a command: status %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+	if w = request(api, http.MethodPost, "/api/status", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST of a read: status %d", w.Code)
+	}
+	if w = request(api, http.MethodGet, "/api/nothing", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown path: status %d", w.Code)
+	}
+	if w = request(api, http.MethodPost, "/api/profile", nil); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "unknown profile") {
+		t.Errorf("invalid params: status %d, body %s", w.Code, w.Body)
+	}
+	if w = request(api, http.MethodGet, "/api/devices", nil); w.Code != http.StatusInternalServerError {
+		t.Errorf("failing method: status %d", w.Code)
+	}
+	if calls := control.Calls(); len(calls) != 1 {
+		t.Errorf("refused requests ran %v", calls[1:])
+	}
+}
+
+func TestEvents(t *testing.T) {
+	api, _ := newAPI("s3cret", "")
+	server := httptest.NewServer(api)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/events"
+
+	if _, err := websocket.Dial(url); err == nil {
+		t.Fatal("websocket opened without the token")
+	}
+	conn, err := websocket.Dial(url + "?token=s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status map[string]interface{}
+	if err := json.Unmarshal(data, &status); err != nil || status["type"] != "status" || status["device"] != "Microphone" {
+		t.Fatalf("first message %s", data)
+	}
+
+	//? The client is registered before the status is sent, so nothing after it is missed
+	api.Broadcast(map[string]interface{}{"type": "open", "open": true})
+	_, data, err = conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"open":true,"type":"open"}` {
+		t.Errorf("event %s", data)
+	}
+}
+
+func TestCloseClosesWebsockets(t *testing.T) {
+	api, _ := newAPI("", "")
+	server := httptest.NewServer(api)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/events"
+	conn, err := websocket.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	api.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	//? The close frame from the server ends the read with io.EOF
+	if _, _, err := conn.ReadMessage(); err != io.EOF {
+		t.Fatalf("read after Close returned %v, want io.EOF", err)
+	}
+
+	//? A websocket opened after Close is closed right away
+	late, err := websocket.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+	late.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := late.ReadMessage(); err == nil {
+		t.Fatal("websocket opened after Close got a message")
+	}
+}
+
+func TestEventsRefusesOtherOrigins(t *testing.T) {
+	api, _ := newAPI("", "")
+	w := request(api, http.MethodGet, "/api/events", map[string]string{
+		"Origin":                "https://evil.example",
+		"Connection":            "Upgrade",
+		"Upgrade":               "websocket",
+		"Sec-WebSocket-Version": "13",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+	})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("websocket from another origin: status %d, want 403", w.Code)
+	}
+}
+
+func TestSlowClientIsDropped(t *testing.T) {
+	api, _ := newAPI("", "")
+	client := make(chan []byte, clientBuffer)
+	api.clients[client] = nil
+	for i := 0; i <= clientBuffer; i++ {
+		api.Broadcast(i)
+	}
+	if _, ok := api.clients[client]; ok {
+		t.Fatal("client with a full buffer wasn't dropped")
+	}
+	received := 0
+	for range client {
+		received++
+	}
+	if received != clientBuffer {
+		t.Errorf("client got %d events before it was dropped, want %d", received, clientBuffer)
+	}
+}
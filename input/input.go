@@ -0,0 +1,93 @@
+// Package input reconciles what the engine thinks is held with the physical state of the keys, and keeps the input hooks running.
+package input
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State tells whether a key or mouse button is physically down, by virtual key code
+type State interface {
+	IsDown(vk int) (bool, error)
+}
+
+// FakeState is a State that returns what it is told, for testing without hardware
+type FakeState struct {
+	mu   sync.Mutex
+	down map[int]bool
+}
+
+// SetDown sets the state IsDown returns for a key
+func (s *FakeState) SetDown(vk int, down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down == nil {
+		s.down = make(map[int]bool)
+	}
+	s.down[vk] = down
+}
+
+func (s *FakeState) IsDown(vk int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.down[vk], nil
+}
+
+// Reconciler compares the bindings the engine thinks are held with the physical state of the keys,
+// and releases a binding whose up event got lost, for example to a UAC prompt.
+type Reconciler struct {
+	State   State
+	Keys    []int       // Virtual key codes of the push-to-talk bindings
+	IsHeld  func() bool // Whether the engine thinks a binding is held
+	Release func()      // Synthetic release
+	Misses  int         // Checks in a row the keys have to be up before releasing, so a release that is still on its way isn't raced
+
+	misses int
+}
+
+// Check reads the keys once and returns true if it issued a synthetic release
+func (r *Reconciler) Check() bool {
+	if !r.IsHeld() {
+		r.misses = 0
+		return false
+	}
+	for _, vk := range r.Keys {
+		down, err := r.State.IsDown(vk)
+		if err != nil {
+			fmt.Println("Error reading key state", err)
+			r.misses = 0
+			return false
+		}
+		if down {
+			r.misses = 0
+			return false
+		}
+	}
+	r.misses++
+	if r.misses < r.Misses {
+		return false
+	}
+	r.misses = 0
+	r.Release()
+	return true
+}
+
+// RunReconciler checks every interval until stop is closed
+func RunReconciler(r *Reconciler, interval time.Duration, stop <-chan struct{}) {
+	if len(r.Keys) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if r.Check() {
+				fmt.Println("Binding is held but no bound key is down, releasing it")
+			}
+		}
+	}
+}
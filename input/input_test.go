@@ -0,0 +1,156 @@
+package input
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingState fails every read
+type failingState struct{}
+
+func (failingState) IsDown(vk int) (bool, error) {
+	return false, errors.New("no input desktop")
+}
+
+// reconciled is a reconciler over fake keys 0x41 and 0x01 that counts its releases
+func reconciled(state State, held *bool) (*Reconciler, *int) {
+	releases := 0
+	r := &Reconciler{
+		State:   state,
+		Keys:    []int{0x41, 0x01},
+		IsHeld:  func() bool { return *held },
+		Release: func() { releases++; *held = false },
+		Misses:  2,
+	}
+	return r, &releases
+}
+
+func TestReconcilerReleasesLostUp(t *testing.T) {
+	state := &FakeState{}
+	held := true
+	r, releases := reconciled(state, &held)
+	if r.Check() {
+		t.Fatal("released on the first miss")
+	}
+	if !r.Check() || *releases != 1 {
+		t.Fatalf("not released after %d misses, releases = %d", r.Misses, *releases)
+	}
+	if r.Check() || *releases != 1 {
+		t.Fatal("released again once the engine let go")
+	}
+}
+
+func TestReconcilerKeepsHeldKeys(t *testing.T) {
+	for _, vk := range []int{0x41, 0x01} {
+		state := &FakeState{}
+		state.SetDown(vk, true)
+		held := true
+		r, releases := reconciled(state, &held)
+		for i := 0; i < 5; i++ {
+			if r.Check() {
+				t.Fatalf("released while key %#x is down", vk)
+			}
+		}
+		if *releases != 0 {
+			t.Fatalf("releases = %d", *releases)
+		}
+	}
+}
+
+func TestReconcilerKeyDownResetsMisses(t *testing.T) {
+	state := &FakeState{}
+	held := true
+	r, releases := reconciled(state, &held)
+	r.Check()
+	state.SetDown(0x41, true)
+	r.Check()
+	state.SetDown(0x41, false)
+	if r.Check() {
+		t.Fatal("misses before the key went down counted")
+	}
+	if !r.Check() || *releases != 1 {
+		t.Fatal("not released after two misses in a row")
+	}
+}
+
+func TestReconcilerIgnoresKeysWhenNothingHeld(t *testing.T) {
+	state := &FakeState{}
+	held := false
+	r, releases := reconciled(state, &held)
+	for i := 0; i < 5; i++ {
+		r.Check()
+	}
+	if *releases != 0 {
+		t.Fatalf("released %d times with nothing held", *releases)
+	}
+	//? Misses counted while nothing was held don't carry over
+	held = true
+	if r.Check() {
+		t.Fatal("released on the first miss after a hold started")
+	}
+}
+
+func TestReconcilerReadError(t *testing.T) {
+	held := true
+	r, releases := reconciled(failingState{}, &held)
+	for i := 0; i < 5; i++ {
+		r.Check()
+	}
+	if *releases != 0 {
+		t.Fatal("released on a failed key read")
+	}
+}
+
+func TestRunReconciler(t *testing.T) {
+	state := &FakeState{}
+	state.SetDown(0x41, true)
+	released := make(chan struct{}, 1)
+	held := make(chan bool, 1)
+	held <- true
+	isHeld := func() bool {
+		h := <-held
+		held <- h
+		return h
+	}
+	r := &Reconciler{
+		State:   state,
+		Keys:    []int{0x41},
+		IsHeld:  isHeld,
+		Release: func() { <-held; held <- false; released <- struct{}{} },
+		Misses:  2,
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		RunReconciler(r, time.Millisecond, stop)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-released:
+		t.Fatal("released while the key is down")
+	default:
+	}
+	state.SetDown(0x41, false)
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("not released after the key went up")
+	}
+	close(stop)
+	<-done
+}
+
+func TestRunReconcilerWithoutKeys(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		RunReconciler(&Reconciler{}, time.Millisecond, make(chan struct{}))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("runs without keys to check")
+	}
+}
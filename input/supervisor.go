@@ -0,0 +1,143 @@
+package input
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Source is an input hook that feeds the engine
+type Source struct {
+	Name string
+	// Run blocks until stop is closed or the source fails, alive is called for every input the source receives
+	Run       func(stop <-chan struct{}, alive func()) error
+	Heartbeat *Heartbeat // Detects a hook Windows removed without telling, nil disables it
+}
+
+// Heartbeat detects a hook that stopped receiving input: when a key goes down that the hook never saw, the hook is gone.
+// Windows silently removes low level hooks that take longer than LowLevelHooksTimeout.
+type Heartbeat struct {
+	State State
+	Keys  []int         // Virtual key codes the hook should see
+	Grace time.Duration // Slack for the hook running just before the key state changes
+
+	down      map[int]bool
+	lastCheck time.Time
+}
+
+// Reset forgets what was seen, called when the hook is reinstalled
+func (h *Heartbeat) Reset() {
+	h.down = nil
+	h.lastCheck = time.Time{}
+}
+
+// Check reads the keys and returns true if one went down since the last check without the hook seeing any input
+func (h *Heartbeat) Check(lastEvent time.Time, now time.Time) bool {
+	if h.down == nil {
+		h.down = make(map[int]bool)
+	}
+	stalled := false
+	for _, vk := range h.Keys {
+		down, err := h.State.IsDown(vk)
+		if err != nil {
+			continue
+		}
+		if down && !h.down[vk] && !h.lastCheck.IsZero() && lastEvent.Before(h.lastCheck.Add(-h.Grace)) {
+			stalled = true
+		}
+		h.down[vk] = down
+	}
+	h.lastCheck = now
+	return stalled
+}
+
+// Supervisor keeps sources running, restarting them with backoff when they fail, panic or stall
+type Supervisor struct {
+	MinBackoff     time.Duration // Delay before a restart, doubled on every failure in a row
+	MaxBackoff     time.Duration
+	HealthyRunTime time.Duration // A source that ran this long before failing starts over at MinBackoff
+	CheckInterval  time.Duration // How often the heartbeat of a source is checked
+
+	OnStatus func(name, status string) // Told about the health of the sources, may be nil
+}
+
+// Run runs the source until stop is closed
+func (s *Supervisor) Run(source Source, stop <-chan struct{}) {
+	backoff := s.MinBackoff
+	for {
+		started := time.Now()
+		var lastEvent int64 = started.UnixNano()
+		alive := func() {
+			atomic.StoreInt64(&lastEvent, time.Now().UnixNano())
+		}
+		if source.Heartbeat != nil {
+			source.Heartbeat.Reset()
+		}
+
+		runStop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			//? A panicking source is restarted like a failing one
+			defer func() {
+				if r := recover(); r != nil {
+					done <- fmt.Errorf("panic: %v", r)
+				}
+			}()
+			done <- source.Run(runStop, alive)
+		}()
+		s.status(source.Name, "ok")
+
+		failure := s.wait(source, stop, runStop, done, &lastEvent)
+		if failure == "" {
+			return
+		}
+		if time.Since(started) >= s.HealthyRunTime {
+			backoff = s.MinBackoff
+		}
+		fmt.Printf("%s %s, restarting in %v\n", source.Name, failure, backoff)
+		s.status(source.Name, failure+", restarting")
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// wait waits for one run of the source, returns why it has to be restarted or "" when stop was closed
+func (s *Supervisor) wait(source Source, stop <-chan struct{}, runStop chan struct{}, done chan error, lastEvent *int64) string {
+	ticker := time.NewTicker(s.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			close(runStop)
+			<-done
+			return ""
+		case err := <-done:
+			if err == nil {
+				return "stopped"
+			}
+			return fmt.Sprint("failed: ", err)
+		case now := <-ticker.C:
+			if source.Heartbeat == nil {
+				continue
+			}
+			if source.Heartbeat.Check(time.Unix(0, atomic.LoadInt64(lastEvent)), now) {
+				close(runStop)
+				<-done
+				return "stopped receiving input"
+			}
+		}
+	}
+}
+
+func (s *Supervisor) status(name, status string) {
+	if s.OnStatus != nil {
+		s.OnStatus(name, status)
+	}
+}
@@ -0,0 +1,309 @@
+package input
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatStall(t *testing.T) {
+	state := &FakeState{}
+	h := &Heartbeat{State: state, Keys: []int{0x41, 0x01}, Grace: 100 * time.Millisecond}
+	start := time.Unix(1000, 0)
+	lastEvent := start
+
+	//? The first check only learns the keys, a key already down says nothing
+	state.SetDown(0x41, true)
+	if h.Check(lastEvent, start) {
+		t.Fatal("stalled on the first check")
+	}
+	state.SetDown(0x41, false)
+	if h.Check(lastEvent, start.Add(time.Second)) {
+		t.Fatal("stalled on a key going up")
+	}
+	state.SetDown(0x01, true)
+	if !h.Check(lastEvent, start.Add(2*time.Second)) {
+		t.Fatal("key went down without input and no stall")
+	}
+	//? A key that stays down only counts once
+	if h.Check(lastEvent, start.Add(3*time.Second)) {
+		t.Fatal("stalled again on a key held down")
+	}
+}
+
+func TestHeartbeatSeenInput(t *testing.T) {
+	state := &FakeState{}
+	h := &Heartbeat{State: state, Keys: []int{0x41}, Grace: 100 * time.Millisecond}
+	start := time.Unix(1000, 0)
+	h.Check(start, start)
+
+	state.SetDown(0x41, true)
+	if h.Check(start.Add(500*time.Millisecond), start.Add(time.Second)) {
+		t.Error("stalled although the hook saw input since the last check")
+	}
+
+	state.SetDown(0x41, false)
+	h.Check(start, start.Add(2*time.Second))
+	//? The hook ran just before the last check, the key went down right after, that is within the grace
+	state.SetDown(0x41, true)
+	if h.Check(start.Add(2*time.Second-50*time.Millisecond), start.Add(3*time.Second)) {
+		t.Error("stalled on input within the grace window")
+	}
+
+	state.SetDown(0x41, false)
+	h.Check(start, start.Add(4*time.Second))
+	state.SetDown(0x41, true)
+	if !h.Check(start.Add(4*time.Second-150*time.Millisecond), start.Add(5*time.Second)) {
+		t.Error("input from before the grace window hid the stall")
+	}
+}
+
+func TestHeartbeatResetAndErrors(t *testing.T) {
+	state := &FakeState{}
+	h := &Heartbeat{State: state, Keys: []int{0x41}}
+	start := time.Unix(1000, 0)
+	h.Check(start, start)
+	h.Reset()
+	state.SetDown(0x41, true)
+	if h.Check(start, start.Add(time.Second)) {
+		t.Error("stalled on the first check after Reset")
+	}
+
+	h = &Heartbeat{State: failingState{}, Keys: []int{0x41}}
+	h.Check(start, start)
+	if h.Check(start, start.Add(time.Second)) {
+		t.Error("stalled on keys that can't be read")
+	}
+}
+
+// statuses passes on what the supervisor reports
+type statuses struct {
+	seen chan string
+}
+
+func newStatuses() *statuses {
+	return &statuses{seen: make(chan string, 64)}
+}
+
+func (s *statuses) report(name, status string) {
+	//? A test that stopped looking mustn't block the supervisor before it is stopped
+	select {
+	case s.seen <- status:
+	default:
+	}
+}
+
+// Wait waits for a status that starts with prefix, failing on any other status but "ok"
+func (s *statuses) Wait(t *testing.T, prefix string) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case status := <-s.seen:
+			if strings.HasPrefix(status, prefix) {
+				return
+			}
+			if status != "ok" {
+				t.Fatalf("status %q while waiting for %q", status, prefix)
+			}
+		case <-timeout:
+			t.Fatalf("no status %q", prefix)
+		}
+	}
+}
+
+func supervisor(s *statuses) *Supervisor {
+	return &Supervisor{
+		MinBackoff:     10 * time.Millisecond,
+		MaxBackoff:     40 * time.Millisecond,
+		HealthyRunTime: time.Hour,
+		CheckInterval:  5 * time.Millisecond,
+		OnStatus:       s.report,
+	}
+}
+
+// runSupervised runs the supervisor until the test ends and checks that it returns after stop
+func runSupervised(t *testing.T, s *Supervisor, source Source) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(source, stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Error("Run didn't return after stop")
+		}
+	})
+}
+
+func TestSupervisorBackoff(t *testing.T) {
+	var mu sync.Mutex
+	var starts []time.Time
+	source := Source{Name: "hook", Run: func(stop <-chan struct{}, alive func()) error {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+		return errors.New("no hook")
+	}}
+	reported := newStatuses()
+	runSupervised(t, supervisor(reported), source)
+	for i := 0; i < 5; i++ {
+		reported.Wait(t, "failed: no hook, restarting")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	//? 10, 20, 40 and then capped at 40
+	want := []time.Duration{10, 20, 40, 40}
+	for i, delay := range want {
+		if gap := starts[i+1].Sub(starts[i]); gap < delay*time.Millisecond {
+			t.Errorf("restart %d after %v, want at least %v", i+1, gap, delay*time.Millisecond)
+		}
+	}
+}
+
+func TestSupervisorBackoffResetsAfterHealthyRun(t *testing.T) {
+	var mu sync.Mutex
+	var runs int
+	var lastEnd, restart time.Time
+	source := Source{Name: "hook", Run: func(stop <-chan struct{}, alive func()) error {
+		mu.Lock()
+		runs++
+		run := runs
+		if run == 5 {
+			restart = time.Now()
+		}
+		mu.Unlock()
+		//? Three quick failures push the backoff to the cap, the fourth run is healthy before it fails
+		if run == 4 {
+			time.Sleep(60 * time.Millisecond)
+			mu.Lock()
+			lastEnd = time.Now()
+			mu.Unlock()
+		}
+		if run >= 5 {
+			<-stop
+			return nil
+		}
+		return errors.New("no hook")
+	}}
+	reported := newStatuses()
+	s := supervisor(reported)
+	s.MaxBackoff = 200 * time.Millisecond
+	s.HealthyRunTime = 50 * time.Millisecond
+	runSupervised(t, s, source)
+	for i := 0; i < 4; i++ {
+		reported.Wait(t, "failed")
+	}
+	reported.Wait(t, "ok")
+
+	mu.Lock()
+	defer mu.Unlock()
+	//? Without the reset the delay would be 80ms
+	if gap := restart.Sub(lastEnd); gap > 60*time.Millisecond {
+		t.Errorf("restarted %v after a healthy run, want about %v", gap, s.MinBackoff)
+	}
+}
+
+func TestSupervisorRestartsStalledHook(t *testing.T) {
+	state := &FakeState{}
+	stopped := make(chan struct{}, 4)
+	source := Source{
+		Name: "hook",
+		Run: func(stop <-chan struct{}, alive func()) error {
+			<-stop
+			stopped <- struct{}{}
+			return nil
+		},
+		Heartbeat: &Heartbeat{State: state, Keys: []int{0x41}, Grace: time.Millisecond},
+	}
+	reported := newStatuses()
+	runSupervised(t, supervisor(reported), source)
+	reported.Wait(t, "ok")
+	//? Let a few checks pass so the heartbeat knows the key is up
+	time.Sleep(30 * time.Millisecond)
+	state.SetDown(0x41, true)
+	reported.Wait(t, "stopped receiving input, restarting")
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("stalled hook wasn't stopped before the restart")
+	}
+	reported.Wait(t, "ok")
+}
+
+func TestSupervisorKeepsHookThatSeesInput(t *testing.T) {
+	state := &FakeState{}
+	source := Source{
+		Name: "hook",
+		Run: func(stop <-chan struct{}, alive func()) error {
+			ticker := time.NewTicker(time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return nil
+				case <-ticker.C:
+					alive()
+				}
+			}
+		},
+		Heartbeat: &Heartbeat{State: state, Keys: []int{0x41}, Grace: 20 * time.Millisecond},
+	}
+	reported := newStatuses()
+	runSupervised(t, supervisor(reported), source)
+	reported.Wait(t, "ok")
+	time.Sleep(30 * time.Millisecond)
+	state.SetDown(0x41, true)
+	select {
+	case status := <-reported.seen:
+		t.Fatalf("status %q for a hook that sees input", status)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSupervisorRestartsAfterPanic(t *testing.T) {
+	var mu sync.Mutex
+	runs := 0
+	source := Source{Name: "hook", Run: func(stop <-chan struct{}, alive func()) error {
+		mu.Lock()
+		runs++
+		run := runs
+		mu.Unlock()
+		if run == 1 {
+			panic("boom")
+		}
+		<-stop
+		return nil
+	}}
+	reported := newStatuses()
+	runSupervised(t, supervisor(reported), source)
+	reported.Wait(t, "failed: panic: boom, restarting")
+	reported.Wait(t, "ok")
+}
+
+func TestSupervisorRestartsStoppedSource(t *testing.T) {
+	var mu sync.Mutex
+	runs := 0
+	source := Source{Name: "hook", Run: func(stop <-chan struct{}, alive func()) error {
+		mu.Lock()
+		runs++
+		run := runs
+		mu.Unlock()
+		if run == 1 {
+			return nil
+		}
+		<-stop
+		return nil
+	}}
+	reported := newStatuses()
+	runSupervised(t, supervisor(reported), source)
+	reported.Wait(t, "stopped, restarting")
+	reported.Wait(t, "ok")
+}
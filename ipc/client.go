@@ -0,0 +1,70 @@
+package ipc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Client calls the methods of a server over one connection
+type Client struct {
+	mu     sync.Mutex
+	conn   io.ReadWriteCloser
+	reader *bufio.Reader
+	nextID int
+}
+
+func NewClient(conn io.ReadWriteCloser) *Client {
+	return &Client{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Call runs a method and decodes its result into result, which may be nil
+func (c *Client) Call(method string, params interface{}, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	id := json.RawMessage(fmt.Sprint(c.nextID))
+	request := Request{JSONRPC: "2.0", ID: id, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		request.Params = data
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	var response Response
+	if err := json.Unmarshal(line, &response); err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if string(response.ID) != string(id) {
+		return errors.New("ipc: response to another request")
+	}
+	if result == nil || response.Result == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,181 @@
+package ipc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve starts a server with a few test methods on a fresh name, it is closed when the test ends
+func serve(t *testing.T) string {
+	t.Helper()
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	name := fmt.Sprintf("muteiny-test-%d-%d", os.Getpid(), time.Now().UnixNano())
+
+	server := NewServer()
+	server.Handle("echo", func(params json.RawMessage) (interface{}, error) {
+		var args struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, InvalidParams(err)
+		}
+		if args.Text == "" {
+			return nil, InvalidParams(errors.New("text is required"))
+		}
+		return map[string]string{"text": args.Text}, nil
+	})
+	server.Handle("fail", func(params json.RawMessage) (interface{}, error) {
+		return nil, errors.New("broken")
+	})
+	server.Handle("panic", func(params json.RawMessage) (interface{}, error) {
+		panic("handler panicked")
+	})
+
+	listener, err := Listen(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		server.Serve(listener)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Serve didn't return after Close")
+		}
+	})
+	return name
+}
+
+func dial(t *testing.T, name string) *Client {
+	t.Helper()
+	conn, err := Dial(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(conn)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestCall(t *testing.T) {
+	client := dial(t, serve(t))
+	for _, text := range []string{"one", "two"} {
+		var result struct {
+			Text string `json:"text"`
+		}
+		if err := client.Call("echo", map[string]string{"text": text}, &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Text != text {
+			t.Fatalf("echo = %q, want %q", result.Text, text)
+		}
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	client := dial(t, serve(t))
+	tests := []struct {
+		method string
+		params interface{}
+		code   int
+	}{
+		{"missing", nil, CodeMethodNotFound},
+		{"echo", nil, CodeInvalidParams},
+		{"echo", map[string]string{}, CodeInvalidParams},
+		{"fail", nil, CodeInternalError},
+		{"panic", nil, CodeInternalError},
+	}
+	for _, test := range tests {
+		err := client.Call(test.method, test.params, nil)
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) || rpcErr.Code != test.code {
+			t.Fatalf("%s: error = %v, want code %d", test.method, err, test.code)
+		}
+	}
+	//? The connection still works after errors
+	if err := client.Call("echo", map[string]string{"text": "still here"}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRawLines(t *testing.T) {
+	conn, err := Dial(serve(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	//? The notification gets no response, so the first line read answers the request after it
+	lines := strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"echo","params":{"text":"quiet"}}`,
+		``,
+		`not json`,
+		`{"jsonrpc":"1.0","id":7,"method":"echo"}`,
+		`{"jsonrpc":"2.0","id":"a","method":"echo","params":{"text":"hi"}}`,
+	}, "\n") + "\n"
+	if _, err := conn.Write([]byte(lines)); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		id   string
+		code int
+	}{
+		{"null", CodeParseError},
+		{"7", CodeInvalidRequest},
+		{`"a"`, 0},
+	}
+	for _, w := range want {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response Response
+		if err := json.Unmarshal(line, &response); err != nil {
+			t.Fatal(err)
+		}
+		if string(response.ID) != w.id {
+			t.Fatalf("response id = %s, want %s: %s", response.ID, w.id, line)
+		}
+		code := 0
+		if response.Error != nil {
+			code = response.Error.Code
+		}
+		if code != w.code {
+			t.Fatalf("response code = %d, want %d: %s", code, w.code, line)
+		}
+	}
+}
+
+func TestConcurrentClients(t *testing.T) {
+	name := serve(t)
+	errs := make(chan error)
+	for i := 0; i < 5; i++ {
+		client := dial(t, name)
+		go func(i int) {
+			var result struct {
+				Text string `json:"text"`
+			}
+			err := client.Call("echo", map[string]string{"text": fmt.Sprint(i)}, &result)
+			if err == nil && result.Text != fmt.Sprint(i) {
+				err = fmt.Errorf("client %d got %q", i, result.Text)
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
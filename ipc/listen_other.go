@@ -0,0 +1,57 @@
+//go:build !windows
+
+package ipc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Path returns the socket of the named server, in $XDG_RUNTIME_DIR or the temp directory
+func Path(name string) string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, name+".sock")
+}
+
+type socketListener struct {
+	net.Listener
+}
+
+func (l socketListener) Accept() (io.ReadWriteCloser, error) {
+	conn, err := l.Listener.Accept()
+	if errors.Is(err, net.ErrClosed) {
+		return nil, ErrClosed
+	}
+	return conn, err
+}
+
+// Listen creates the Unix socket of the named server, only the current user can connect
+func Listen(name string) (Listener, error) {
+	path := Path(name)
+	//? A socket left by a crashed server would make Listen fail, but don't take over a live one
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, errors.New("ipc: another server is listening on " + path)
+	}
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return socketListener{listener}, nil
+}
+
+// Dial connects to the named server
+func Dial(name string) (io.ReadWriteCloser, error) {
+	return net.Dial("unix", Path(name))
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+
+package ipc
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestListenRefusesLiveServer(t *testing.T) {
+	name := serve(t)
+	if listener, err := Listen(name); err == nil {
+		listener.Close()
+		t.Fatal("second server took over the socket")
+	}
+	//? The first server still answers
+	if err := dial(t, name).Call("echo", map[string]string{"text": "first"}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	//? A socket file nobody listens on, like the one a crashed server leaves
+	stale, err := net.Listen("unix", Path("stale"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Stat(Path("stale")); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := Listen("stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	info, err := os.Stat(Path("stale"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode = %v, want 0600", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,124 @@
+package ipc
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+var procDisconnectNamedPipe = windows.NewLazySystemDLL("kernel32.dll").NewProc("DisconnectNamedPipe")
+
+// How long Dial waits for a busy pipe
+const dialTimeout = 2 * time.Second
+
+// Path returns the named pipe of the named server
+func Path(name string) string {
+	return `\\.\pipe\` + name
+}
+
+// pipeListener hands out one pipe instance per connection, the next instance is created
+// before a connection is returned so a client never finds the pipe missing
+type pipeListener struct {
+	path string
+
+	mu     sync.Mutex
+	next   windows.Handle
+	closed bool
+}
+
+func createPipe(path string, first bool) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+	flags := uint32(windows.PIPE_ACCESS_DUPLEX)
+	if first {
+		//? Fails if another process owns the pipe, so nobody can listen in on our clients
+		flags |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	return windows.CreateNamedPipe(name, flags,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES, 4096, 4096, 0, nil)
+}
+
+// Listen creates the named pipe of the named server
+func Listen(name string) (Listener, error) {
+	path := Path(name)
+	pipe, err := createPipe(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeListener{path: path, next: pipe}, nil
+}
+
+func (l *pipeListener) Accept() (io.ReadWriteCloser, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, ErrClosed
+	}
+	pipe := l.next
+	l.mu.Unlock()
+
+	err := windows.ConnectNamedPipe(pipe, nil)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	//? Close owns the pending instance, it may already be gone
+	if l.closed {
+		return nil, ErrClosed
+	}
+	if err != nil && !errors.Is(err, windows.ERROR_PIPE_CONNECTED) {
+		//? The instance stays pending for the next Accept
+		procDisconnectNamedPipe.Call(uintptr(pipe))
+		return nil, err
+	}
+	next, err := createPipe(l.path, false)
+	if err != nil {
+		windows.CloseHandle(pipe)
+		return nil, err
+	}
+	l.next = next
+	return os.NewFile(uintptr(pipe), l.path), nil
+}
+
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+	//? ConnectNamedPipe can't be cancelled, connecting ourselves wakes Accept up
+	if conn, err := Dial(l.path[len(`\\.\pipe\`):]); err == nil {
+		conn.Close()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return windows.CloseHandle(l.next)
+}
+
+// Dial connects to the named server
+func Dial(name string) (io.ReadWriteCloser, error) {
+	path, err := windows.UTF16PtrFromString(Path(name))
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(dialTimeout)
+	for {
+		pipe, err := windows.CreateFile(path, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, 0, 0)
+		if err == nil {
+			return os.NewFile(uintptr(pipe), Path(name)), nil
+		}
+		//? Every instance is taken until the server creates the next one
+		if !errors.Is(err, windows.ERROR_PIPE_BUSY) || time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
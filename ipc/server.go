@@ -0,0 +1,188 @@
+// Package ipc is the local control API of Muteiny, JSON-RPC 2.0 with one message per line
+// over a named pipe on Windows and a Unix socket elsewhere.
+package ipc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Longest request line the server reads
+const maxMessageSize = 64 * 1024
+
+// Wait after a failed Accept so a broken listener doesn't spin
+const acceptRetryDelay = 100 * time.Millisecond
+
+// Request is a JSON-RPC request, a request without id is a notification and gets no response
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC response
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error, a handler can return one to choose the code
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// InvalidParams is the error for params a handler can't use
+func InvalidParams(err error) *Error {
+	return &Error{Code: CodeInvalidParams, Message: err.Error()}
+}
+
+// Handler runs a method, params is null when the request has none
+type Handler func(params json.RawMessage) (interface{}, error)
+
+// Listener accepts connections, a named pipe instance or a socket connection
+type Listener interface {
+	Accept() (io.ReadWriteCloser, error)
+	Close() error
+}
+
+// ErrClosed is returned by Accept after Close
+var ErrClosed = errors.New("ipc: listener closed")
+
+// Server dispatches the requests of its connections to the registered methods
+type Server struct {
+	mu      sync.Mutex
+	methods map[string]Handler
+}
+
+func NewServer() *Server {
+	return &Server{methods: make(map[string]Handler)}
+}
+
+// Handle registers the handler of a method
+func (s *Server) Handle(method string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[method] = handler
+}
+
+// Methods returns the registered method names
+func (s *Server) Methods() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.methods))
+	for name := range s.methods {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Serve accepts connections until the listener is closed, a failed connection doesn't stop it
+func (s *Server) Serve(listener Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, ErrClosed) {
+			return
+		} else if err != nil {
+			time.Sleep(acceptRetryDelay)
+			continue
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn answers the requests of one connection until it is closed
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxMessageSize)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		response := s.handle(line)
+		if response == nil {
+			continue
+		}
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+	}
+}
+
+// handle runs one request line, nil means there is nothing to answer
+func (s *Server) handle(line []byte) *Response {
+	var request Request
+	if err := json.Unmarshal(line, &request); err != nil {
+		return errorResponse(nil, &Error{Code: CodeParseError, Message: err.Error()})
+	}
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		return errorResponse(request.ID, &Error{Code: CodeInvalidRequest, Message: "not a JSON-RPC 2.0 request"})
+	}
+	result, err := s.Call(request.Method, request.Params)
+	if request.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return errorResponse(request.ID, err)
+	}
+	return &Response{JSONRPC: "2.0", ID: request.ID, Result: result}
+}
+
+// Call runs a method without a connection, for other front ends like the HTTP API
+func (s *Server) Call(method string, params json.RawMessage) (result json.RawMessage, rpcErr *Error) {
+	s.mu.Lock()
+	handler, ok := s.methods[method]
+	s.mu.Unlock()
+	if !ok {
+		return nil, &Error{Code: CodeMethodNotFound, Message: "unknown method " + method}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result, rpcErr = nil, &Error{Code: CodeInternalError, Message: fmt.Sprint(r)}
+		}
+	}()
+	value, err := handler(params)
+	if err != nil {
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeInternalError, Message: err.Error()}
+		}
+		return nil, rpcErr
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, &Error{Code: CodeInternalError, Message: err.Error()}
+	}
+	return data, nil
+}
+
+func errorResponse(id json.RawMessage, err *Error) *Response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &Response{JSONRPC: "2.0", ID: id, Error: err}
+}
@@ -0,0 +1,107 @@
+// Package level turns sequences of peak meter readings into decisions: voice activation, silence and talking while muted.
+// It has no Windows dependencies so the logic can be tested with synthetic levels.
+package level
+
+import (
+	"fmt"
+	"time"
+)
+
+// Meter reads the current peak level of a capture device, from 0 to 1
+type Meter interface {
+	Peak() (float32, error)
+}
+
+// VoiceGate decides from a sequence of peak levels when the mic should be open.
+// It opens as soon as the level reaches Attack and closes once the level has stayed below Release for ReleaseTime.
+type VoiceGate struct {
+	Attack      float32       // Level that opens the gate
+	Release     float32       // Level the input has to stay below to close the gate, lower than Attack
+	ReleaseTime time.Duration // How long the input has to stay below Release
+
+	open       bool
+	quietSince time.Time
+}
+
+// Update feeds a peak level read at now and returns whether the gate is open
+func (g *VoiceGate) Update(level float32, now time.Time) bool {
+	if level >= g.Attack {
+		g.open = true
+		g.quietSince = time.Time{}
+		return true
+	}
+	if !g.open {
+		return false
+	}
+	if level >= g.Release {
+		g.quietSince = time.Time{}
+		return true
+	}
+	if g.quietSince.IsZero() {
+		g.quietSince = now
+	}
+	if now.Sub(g.quietSince) >= g.ReleaseTime {
+		g.open = false
+	}
+	return g.open
+}
+
+// IsOpen returns the current state of the gate
+func (g *VoiceGate) IsOpen() bool {
+	return g.open
+}
+
+// SilenceDetector tells when a sequence of peak levels has stayed below Threshold for Duration
+type SilenceDetector struct {
+	Threshold float32       // Level the input has to stay below
+	Duration  time.Duration // How long the input has to stay below Threshold
+
+	quietSince time.Time
+}
+
+// Update feeds a peak level read at now and returns true once the input has been silent long enough
+func (d *SilenceDetector) Update(level float32, now time.Time) bool {
+	if level >= d.Threshold {
+		d.quietSince = time.Time{}
+		return false
+	}
+	if d.quietSince.IsZero() {
+		d.quietSince = now
+	}
+	return now.Sub(d.quietSince) >= d.Duration
+}
+
+// meterErrors prints a meter error only when it differs from the last one, the meter fails continuously while no device is plugged in
+type meterErrors struct {
+	last error
+}
+
+func (m *meterErrors) report(err error) {
+	if err != nil && (m.last == nil || err.Error() != m.last.Error()) {
+		fmt.Println("Error reading peak meter", err)
+	}
+	m.last = err
+}
+
+// RunVoiceGate polls the meter every interval and calls onChange when the gate opens or closes, until stop is closed
+func RunVoiceGate(meter Meter, gate *VoiceGate, interval time.Duration, stop <-chan struct{}, onChange func(open bool)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var errors meterErrors
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			level, err := meter.Peak()
+			errors.report(err)
+			if err != nil {
+				continue
+			}
+			wasOpen := gate.IsOpen()
+			if open := gate.Update(level, now); open != wasOpen {
+				onChange(open)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package level
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// reading is a peak level at an offset from the start of a sequence
+type reading struct {
+	at    time.Duration
+	level float32
+	want  bool
+}
+
+func TestVoiceGate(t *testing.T) {
+	tests := []struct {
+		name     string
+		readings []reading
+	}{
+		{"stays closed below attack", []reading{
+			{0, 0.1, false},
+			{20 * time.Millisecond, 0.29, false},
+		}},
+		{"opens on attack", []reading{
+			{0, 0.1, false},
+			{20 * time.Millisecond, 0.3, true},
+		}},
+		{"hysteresis keeps it open between release and attack", []reading{
+			{0, 0.5, true},
+			{20 * time.Millisecond, 0.2, true},
+			{500 * time.Millisecond, 0.15, true},
+			{time.Second, 0.1, true},
+		}},
+		{"level between release and attack doesn't open a closed gate", []reading{
+			{0, 0.2, false},
+			{20 * time.Millisecond, 0.25, false},
+		}},
+		{"closes after the release time", []reading{
+			{0, 0.5, true},
+			{20 * time.Millisecond, 0.05, true},
+			{219 * time.Millisecond, 0.05, true},
+			{220 * time.Millisecond, 0.05, false},
+		}},
+		{"release timing starts at the first quiet reading", []reading{
+			{0, 0.5, true},
+			{100 * time.Millisecond, 0.5, true},
+			{120 * time.Millisecond, 0.0, true},
+			{300 * time.Millisecond, 0.0, true},
+			{320 * time.Millisecond, 0.0, false},
+		}},
+		{"re-trigger during release restarts the timer", []reading{
+			{0, 0.5, true},
+			{20 * time.Millisecond, 0.0, true},
+			{150 * time.Millisecond, 0.4, true},
+			{170 * time.Millisecond, 0.0, true},
+			{300 * time.Millisecond, 0.0, true},
+			{370 * time.Millisecond, 0.0, false},
+		}},
+		{"level above release during release restarts the timer", []reading{
+			{0, 0.5, true},
+			{20 * time.Millisecond, 0.0, true},
+			{150 * time.Millisecond, 0.2, true},
+			{170 * time.Millisecond, 0.0, true},
+			{369 * time.Millisecond, 0.0, true},
+			{370 * time.Millisecond, 0.0, false},
+		}},
+		{"opens again after closing", []reading{
+			{0, 0.5, true},
+			{20 * time.Millisecond, 0.0, true},
+			{220 * time.Millisecond, 0.0, false},
+			{240 * time.Millisecond, 0.3, true},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gate := &VoiceGate{Attack: 0.3, Release: 0.15, ReleaseTime: 200 * time.Millisecond}
+			start := time.Now()
+			for _, r := range test.readings {
+				if got := gate.Update(r.level, start.Add(r.at)); got != r.want {
+					t.Fatalf("at %v level %v: open = %v, want %v", r.at, r.level, got, r.want)
+				}
+				if gate.IsOpen() != r.want {
+					t.Fatalf("at %v: IsOpen disagrees with Update", r.at)
+				}
+			}
+		})
+	}
+}
+
+func TestSilenceDetector(t *testing.T) {
+	tests := []struct {
+		name     string
+		readings []reading
+	}{
+		{"silent after the duration", []reading{
+			{0, 0.01, false},
+			{99 * time.Millisecond, 0.01, false},
+			{100 * time.Millisecond, 0.01, true},
+		}},
+		{"speech restarts the timer", []reading{
+			{0, 0.01, false},
+			{80 * time.Millisecond, 0.2, false},
+			{100 * time.Millisecond, 0.01, false},
+			{180 * time.Millisecond, 0.01, false},
+			{200 * time.Millisecond, 0.01, true},
+		}},
+		{"level at the threshold is not silence", []reading{
+			{0, 0.05, false},
+			{200 * time.Millisecond, 0.05, false},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detector := &SilenceDetector{Threshold: 0.05, Duration: 100 * time.Millisecond}
+			start := time.Now()
+			for _, r := range test.readings {
+				if got := detector.Update(r.level, start.Add(r.at)); got != r.want {
+					t.Fatalf("at %v level %v: silent = %v, want %v", r.at, r.level, got, r.want)
+				}
+			}
+		})
+	}
+}
+
+// fakeMeter returns the levels in order, then the last one
+type fakeMeter struct {
+	levels []float32
+	err    error
+}
+
+func (m *fakeMeter) Peak() (float32, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	level := m.levels[0]
+	if len(m.levels) > 1 {
+		m.levels = m.levels[1:]
+	}
+	return level, nil
+}
+
+func TestRunVoiceGate(t *testing.T) {
+	meter := &fakeMeter{levels: []float32{0, 0.5, 0.5, 0}}
+	gate := &VoiceGate{Attack: 0.3, Release: 0.15, ReleaseTime: 10 * time.Millisecond}
+	stop := make(chan struct{})
+	changes := make(chan bool, 4)
+	done := make(chan struct{})
+	go func() {
+		RunVoiceGate(meter, gate, time.Millisecond, stop, func(open bool) { changes <- open })
+		close(done)
+	}()
+	for _, want := range []bool{true, false} {
+		select {
+		case got := <-changes:
+			if got != want {
+				t.Fatalf("change = %v, want %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no change to %v", want)
+		}
+	}
+	close(stop)
+	<-done
+}
+
+func TestRunVoiceGateMeterError(t *testing.T) {
+	meter := &fakeMeter{err: errors.New("no device")}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		RunVoiceGate(meter, &VoiceGate{Attack: 0.3}, time.Millisecond, stop, func(bool) { t.Error("gate changed without a meter") })
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	<-done
+}
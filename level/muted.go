@@ -0,0 +1,69 @@
+package level
+
+import "time"
+
+// MutedTalkDetector tells from a sequence of peak levels when the user is talking into a muted mic.
+// Speech has to last for Sustain, quiet gaps shorter than Gap don't interrupt it, and warnings are at least Cooldown apart.
+type MutedTalkDetector struct {
+	Threshold float32       // Level that counts as speech
+	Sustain   time.Duration // How long the speech has to last before warning
+	Gap       time.Duration // Longest quiet gap that still counts as the same speech
+	Cooldown  time.Duration // Minimum time between two warnings
+
+	loudSince   time.Time
+	lastLoud    time.Time
+	lastWarning time.Time
+}
+
+// Update feeds a peak level read at now together with the mute state, returns true when the user should be warned
+func (d *MutedTalkDetector) Update(level float32, muted bool, now time.Time) bool {
+	if !muted {
+		d.loudSince = time.Time{}
+		return false
+	}
+	if level >= d.Threshold {
+		if d.loudSince.IsZero() || now.Sub(d.lastLoud) > d.Gap {
+			d.loudSince = now
+		}
+		d.lastLoud = now
+	} else if !d.loudSince.IsZero() && now.Sub(d.lastLoud) > d.Gap {
+		d.loudSince = time.Time{}
+	}
+	if d.loudSince.IsZero() || d.lastLoud.Sub(d.loudSince) < d.Sustain {
+		return false
+	}
+	//? Rate limit, keep talking and you get warned once per cooldown
+	if !d.lastWarning.IsZero() && now.Sub(d.lastWarning) < d.Cooldown {
+		return false
+	}
+	d.lastWarning = now
+	d.loudSince = time.Time{}
+	return true
+}
+
+// RunMutedTalkDetector polls the meter every interval while isMuted says the mic is muted and calls warn, until stop is closed
+func RunMutedTalkDetector(meter Meter, detector *MutedTalkDetector, interval time.Duration, stop <-chan struct{}, isMuted func() bool, warn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var errors meterErrors
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			muted := isMuted()
+			if !muted {
+				detector.Update(0, false, now)
+				continue
+			}
+			level, err := meter.Peak()
+			errors.report(err)
+			if err != nil {
+				continue
+			}
+			if detector.Update(level, muted, now) {
+				warn()
+			}
+		}
+	}
+}
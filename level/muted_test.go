@@ -0,0 +1,101 @@
+package level
+
+import (
+	"testing"
+	"time"
+)
+
+// span feeds a level every 20ms for a while, like the meter does
+type span struct {
+	length time.Duration
+	level  float32
+	muted  bool
+}
+
+func TestMutedTalkDetector(t *testing.T) {
+	const tick = 20 * time.Millisecond
+	tests := []struct {
+		name  string
+		spans []span
+		want  []time.Duration // When the warnings fire, from the start
+	}{
+		{"warns once speech lasts for the sustain period", []span{
+			{time.Second, 0.5, true},
+		}, []time.Duration{500 * time.Millisecond}},
+		{"short speech doesn't warn", []span{
+			{400 * time.Millisecond, 0.5, true},
+			{2 * time.Second, 0, true},
+		}, nil},
+		{"levels below the threshold are not speech", []span{
+			{time.Second, 0.09, true},
+		}, nil},
+		{"gaps within the gap tolerance keep the speech going", []span{
+			{200 * time.Millisecond, 0.5, true},
+			{180 * time.Millisecond, 0, true},
+			{200 * time.Millisecond, 0.5, true},
+		}, []time.Duration{500 * time.Millisecond}},
+		{"a longer gap restarts the sustain period", []span{
+			{400 * time.Millisecond, 0.5, true},
+			{300 * time.Millisecond, 0, true},
+			{time.Second, 0.5, true},
+		}, []time.Duration{1200 * time.Millisecond}},
+		{"cooldown rate limits warnings while talking", []span{
+			{5 * time.Second, 0.5, true},
+		}, []time.Duration{500 * time.Millisecond, 2500 * time.Millisecond, 4500 * time.Millisecond}},
+		{"a new sustain period is needed after the cooldown", []span{
+			{600 * time.Millisecond, 0.5, true},
+			{3 * time.Second, 0, true},
+			{600 * time.Millisecond, 0.5, true},
+		}, []time.Duration{500 * time.Millisecond, 4100 * time.Millisecond}},
+		{"unmuting resets the speech", []span{
+			{400 * time.Millisecond, 0.5, true},
+			{40 * time.Millisecond, 0.5, false},
+			{time.Second, 0.5, true},
+		}, []time.Duration{940 * time.Millisecond}},
+		{"talking while unmuted never warns", []span{
+			{3 * time.Second, 0.5, false},
+		}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detector := &MutedTalkDetector{Threshold: 0.1, Sustain: 500 * time.Millisecond, Gap: 200 * time.Millisecond, Cooldown: 2 * time.Second}
+			start := time.Now()
+			var at time.Duration
+			var got []time.Duration
+			for _, s := range test.spans {
+				for end := at + s.length; at < end; at += tick {
+					if detector.Update(s.level, s.muted, start.Add(at)) {
+						got = append(got, at)
+					}
+				}
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("warnings at %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("warnings at %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunMutedTalkDetector(t *testing.T) {
+	meter := &fakeMeter{levels: []float32{0.5}}
+	detector := &MutedTalkDetector{Threshold: 0.1, Sustain: 5 * time.Millisecond, Gap: 5 * time.Millisecond, Cooldown: time.Hour}
+	stop := make(chan struct{})
+	warned := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		RunMutedTalkDetector(meter, detector, time.Millisecond, stop, func() bool { return true }, func() { warned <- struct{}{} })
+		close(done)
+	}()
+	select {
+	case <-warned:
+	case <-time.After(time.Second):
+		t.Fatal("no warning while talking muted")
+	}
+	close(stop)
+	<-done
+}
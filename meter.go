@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+// How often the peak meter is read
+const meterInterval = 20 * time.Millisecond
+
+// go-wca does not implement IAudioMeterInformation, so this is a minimal version of it
+// https://learn.microsoft.com/en-us/windows/win32/api/endpointvolume/nn-endpointvolume-iaudiometerinformation
+type AudioMeterInformation struct {
+	ole.IUnknown
+}
+
+type audioMeterInformationVtbl struct {
+	ole.IUnknownVtbl
+	GetPeakValue            uintptr
+	GetMeteringChannelCount uintptr
+	GetChannelsPeakValues   uintptr
+	QueryHardwareSupport    uintptr
+}
+
+func (v *AudioMeterInformation) VTable() *audioMeterInformationVtbl {
+	return (*audioMeterInformationVtbl)(unsafe.Pointer(v.RawVTable))
+}
+
+// Peak returns the peak sample value of the endpoint over the last metering period
+func (v *AudioMeterInformation) Peak() (float32, error) {
+	var peak float32
+	hr, _, _ := syscall.SyscallN(
+		v.VTable().GetPeakValue,
+		uintptr(unsafe.Pointer(v)),
+		uintptr(unsafe.Pointer(&peak)))
+	if hr != 0 {
+		return 0, ole.NewError(hr)
+	}
+	return peak, nil
+}
+
+// DefaultMeter reads the peak of the default capture device, following it when it changes.
+// It must be used from a single OLE initialized thread.
+type DefaultMeter struct {
+	deviceName string
+	meter      *AudioMeterInformation
+	release    func()
+}
+
+func (m *DefaultMeter) Peak() (float32, error) {
+	if m.meter == nil || m.deviceName != engine.DeviceName() {
+		m.Close()
+		meter, deviceName, release, err := GetDefaultMeter()
+		if err != nil {
+			return 0, err
+		}
+		m.meter, m.deviceName, m.release = meter, deviceName, release
+	}
+	if m.meter == nil {
+		return 0, errors.New("no meter")
+	}
+	return m.meter.Peak()
+}
+
+// Close releases the meter of the current device
+func (m *DefaultMeter) Close() {
+	if m.release != nil {
+		m.release()
+	}
+	m.meter, m.deviceName, m.release = nil, "", nil
+}
+
+// GetDefaultMeter activates the peak meter of the default capture device
+func GetDefaultMeter() (*AudioMeterInformation, string, func(), error) {
+	var mmde *wca.IMMDeviceEnumerator
+	if err := wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &mmde); err != nil {
+		return nil, "", nil, err
+	}
+	defer mmde.Release()
+
+	var mmd *wca.IMMDevice
+	if err := mmde.GetDefaultAudioEndpoint(wca.ECapture, wca.DEVICE_STATE_ACTIVE, &mmd); err != nil {
+		return nil, "", nil, err
+	}
+	defer mmd.Release()
+
+	var ps *wca.IPropertyStore
+	if err := mmd.OpenPropertyStore(wca.STGM_READ, &ps); err != nil {
+		return nil, "", nil, err
+	}
+	defer ps.Release()
+
+	var pv wca.PROPVARIANT
+	if err := ps.GetValue(&wca.PKEY_Device_FriendlyName, &pv); err != nil {
+		return nil, "", nil, err
+	}
+
+	var meter *AudioMeterInformation
+	if err := mmd.Activate(wca.IID_IAudioMeterInformation, wca.CLSCTX_ALL, nil, &meter); err != nil {
+		return nil, "", nil, err
+	}
+	return meter, pv.String(), func() { meter.Release() }, nil
+}
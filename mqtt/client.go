@@ -0,0 +1,271 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// How long connecting and waiting for an acknowledgement may take
+const ackTimeout = 10 * time.Second
+
+// Longest client ID every MQTT 3.1.1 broker has to accept
+const MaxClientIDLength = 23
+
+// ClientID joins prefix and name into a client ID every broker accepts. A name that does not
+// fit is cut and followed by a hash of the whole name, so long host names stay apart. The prefix
+// has to be shorter than 17 bytes to leave room for the hash.
+func ClientID(prefix, name string) string {
+	if len(prefix)+len(name) <= MaxClientIDLength {
+		return prefix + name
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	suffix := fmt.Sprintf("-%06x", hash.Sum32()&0xffffff)
+	keep := MaxClientIDLength - len(prefix) - len(suffix)
+	if keep < 0 {
+		keep = 0
+	}
+	//? Cut on a rune boundary so the ID stays valid UTF-8
+	for keep > 0 && !utf8.RuneStart(name[keep]) {
+		keep--
+	}
+	return prefix + name[:keep] + suffix
+}
+
+// Options of a connection
+type Options struct {
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration // Pings are sent this often, 0 uses a minute
+	Will      *Message      // Published by the broker when the connection is lost without a DISCONNECT
+}
+
+// Client is a connection to a broker
+type Client struct {
+	conn      net.Conn
+	onMessage func(Message)
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan Packet
+	err     error
+	done    chan struct{}
+}
+
+// Connect connects to a broker URL like tcp://localhost:1883 or ssl://broker:8883 and starts delivering
+// the messages of the subscriptions to onMessage, which runs on the goroutine that reads the connection.
+func Connect(brokerURL string, options Options, onMessage func(Message)) (*Client, error) {
+	if options.Password != "" && options.Username == "" {
+		return nil, errors.New("mqtt: a password needs a user name")
+	}
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return nil, err
+	}
+	if options.KeepAlive == 0 {
+		options.KeepAlive = time.Minute
+	}
+	dialer := &net.Dialer{Timeout: ackTimeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.Dial("tcp", hostPort(u, "1883"))
+	case "ssl", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u, "8883"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("mqtt: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(ackTimeout))
+	if err := WritePacket(conn, ConnectPacket(options)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	connack, err := ReadPacket(reader)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if connack.Type != CONNACK || len(connack.Body) != 2 {
+		conn.Close()
+		return nil, errors.New("mqtt: expected CONNACK")
+	}
+	if code := connack.Body[1]; code != 0 {
+		conn.Close()
+		return nil, ConnackError(code)
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &Client{conn: conn, onMessage: onMessage, pending: make(map[uint16]chan Packet), done: make(chan struct{})}
+	go c.readLoop(reader, options.KeepAlive)
+	go c.pingLoop(options.KeepAlive)
+	return c, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u.Host
+}
+
+func (c *Client) write(packet Packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(ackTimeout))
+	return WritePacket(c.conn, packet)
+}
+
+func (c *Client) readLoop(reader *bufio.Reader, keepAlive time.Duration) {
+	var err error
+	defer func() {
+		c.mu.Lock()
+		c.err = err
+		for id, waiting := range c.pending {
+			close(waiting)
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		c.conn.Close()
+		close(c.done)
+	}()
+	for {
+		//? The broker answers every ping, a silent broker is gone
+		c.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		var packet Packet
+		packet, err = ReadPacket(reader)
+		if err != nil {
+			return
+		}
+		switch packet.Type {
+		case PUBLISH:
+			message, packetID, parseErr := ParsePublish(packet)
+			if parseErr != nil {
+				err = parseErr
+				return
+			}
+			if message.QoS == 1 {
+				c.write(Packet{Type: PUBACK, Body: appendUint16(nil, packetID)})
+			}
+			if c.onMessage != nil {
+				c.onMessage(message)
+			}
+		case PUBACK, SUBACK:
+			if len(packet.Body) < 2 {
+				continue
+			}
+			packetID := uint16(packet.Body[0])<<8 | uint16(packet.Body[1])
+			c.mu.Lock()
+			waiting := c.pending[packetID]
+			delete(c.pending, packetID)
+			c.mu.Unlock()
+			if waiting != nil {
+				waiting <- packet
+			}
+		}
+	}
+}
+
+func (c *Client) pingLoop(keepAlive time.Duration) {
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.write(Packet{Type: PINGREQ})
+		}
+	}
+}
+
+// request sends a packet with a packet id and waits for its acknowledgement
+func (c *Client) request(build func(packetID uint16) Packet) (Packet, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return Packet{}, c.err
+	}
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	packetID := c.nextID
+	waiting := make(chan Packet, 1)
+	c.pending[packetID] = waiting
+	c.mu.Unlock()
+
+	if err := c.write(build(packetID)); err != nil {
+		c.mu.Lock()
+		delete(c.pending, packetID)
+		c.mu.Unlock()
+		return Packet{}, err
+	}
+	select {
+	case packet, ok := <-waiting:
+		if !ok {
+			return Packet{}, errors.New("mqtt: connection lost")
+		}
+		return packet, nil
+	case <-time.After(ackTimeout):
+		c.mu.Lock()
+		delete(c.pending, packetID)
+		c.mu.Unlock()
+		return Packet{}, errors.New("mqtt: no acknowledgement")
+	}
+}
+
+// Publish sends a message, QoS 1 waits for the broker to acknowledge it
+func (c *Client) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	message := Message{Topic: topic, Payload: payload, QoS: qos, Retain: retain}
+	if qos == 0 {
+		return c.write(PublishPacket(message, 0))
+	}
+	if qos > 1 {
+		return errors.New("mqtt: QoS 2 is not supported")
+	}
+	_, err := c.request(func(packetID uint16) Packet { return PublishPacket(message, packetID) })
+	return err
+}
+
+// Subscribe subscribes to a topic filter with at most QoS 1
+func (c *Client) Subscribe(topic string, qos byte) error {
+	if qos > 1 {
+		qos = 1
+	}
+	suback, err := c.request(func(packetID uint16) Packet { return SubscribePacket(topic, qos, packetID) })
+	if err != nil {
+		return err
+	}
+	if len(suback.Body) < 3 || suback.Body[2] == 0x80 {
+		return fmt.Errorf("mqtt: subscription to %s refused", topic)
+	}
+	return nil
+}
+
+// Done is closed when the connection is lost
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Disconnect closes the connection cleanly, the broker discards the will
+func (c *Client) Disconnect() error {
+	err := c.write(Packet{Type: DISCONNECT})
+	c.conn.Close()
+	<-c.done
+	return err
+}
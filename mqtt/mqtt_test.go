@@ -0,0 +1,382 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// fakeBroker is a stand-in broker that accepts one connection at a time and records the packets it gets
+type fakeBroker struct {
+	listener net.Listener
+	connack  byte // Return code of the CONNACK
+	noAck    bool // Don't acknowledge PUBLISH and SUBSCRIBE
+
+	mu      sync.Mutex
+	packets []Packet
+	conn    net.Conn
+	got     chan Packet
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{listener: listener, got: make(chan Packet, 64)}
+	go b.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		b.mu.Lock()
+		if b.conn != nil {
+			b.conn.Close()
+		}
+		b.mu.Unlock()
+	})
+	return b
+}
+
+func (b *fakeBroker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conn = conn
+		b.mu.Unlock()
+		b.serveConn(conn)
+	}
+}
+
+func (b *fakeBroker) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		packet, err := ReadPacket(reader)
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.packets = append(b.packets, packet)
+		b.mu.Unlock()
+		b.got <- packet
+		switch packet.Type {
+		case CONNECT:
+			WritePacket(conn, Packet{Type: CONNACK, Body: []byte{0, b.connack}})
+			if b.connack != 0 {
+				return
+			}
+		case PUBLISH:
+			message, packetID, _ := ParsePublish(packet)
+			if message.QoS == 1 && !b.noAck {
+				WritePacket(conn, Packet{Type: PUBACK, Body: appendUint16(nil, packetID)})
+			}
+		case SUBSCRIBE:
+			if !b.noAck {
+				granted := packet.Body[len(packet.Body)-1]
+				if strings.Contains(string(packet.Body), "forbidden") {
+					granted = 0x80
+				}
+				WritePacket(conn, Packet{Type: SUBACK, Body: append(packet.Body[:2:2], granted)})
+			}
+		case PINGREQ:
+			WritePacket(conn, Packet{Type: PINGRESP})
+		case DISCONNECT:
+			return
+		}
+	}
+}
+
+// Send writes a packet to the connected client
+func (b *fakeBroker) Send(t *testing.T, packet Packet) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := WritePacket(b.conn, packet); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Drop closes the connection, like a broker restart
+func (b *fakeBroker) Drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.conn.Close()
+}
+
+// Next waits for the next packet of a type, skipping the others
+func (b *fakeBroker) Next(t *testing.T, packetType byte) Packet {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case packet := <-b.got:
+			if packet.Type == packetType {
+				return packet
+			}
+		case <-timeout:
+			t.Fatalf("no packet of type %d", packetType)
+		}
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 127, 128, 16383, 16384, 200000} {
+		packet := Packet{Type: PUBLISH, Flags: 0x03, Body: bytes.Repeat([]byte{'x'}, size)}
+		var buf bytes.Buffer
+		if err := WritePacket(&buf, packet); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadPacket(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if got.Type != packet.Type || got.Flags != packet.Flags || !bytes.Equal(got.Body, packet.Body) {
+			t.Fatalf("%d bytes: read back a different packet", size)
+		}
+	}
+}
+
+func TestReadPacketLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"malformed length", []byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"too large", []byte{0x30, 0xff, 0xff, 0x7f}},
+		{"truncated", []byte{0x30, 0x05, 'a'}},
+	}
+	for _, test := range tests {
+		if _, err := ReadPacket(bufio.NewReader(bytes.NewReader(test.data))); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
+
+func TestClientID(t *testing.T) {
+	tests := []struct {
+		name, host, want string
+	}{
+		{"short", "desk", "muteiny-desk"},
+		{"fits exactly", "abcdefghijklmno", "muteiny-abcdefghijklmno"},
+		{"empty", "", "muteiny-"},
+	}
+	for _, test := range tests {
+		if got := ClientID("muteiny-", test.host); got != test.want {
+			t.Errorf("%s: ClientID = %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	long := ClientID("muteiny-", "studio-workstation-upstairs")
+	if len(long) != MaxClientIDLength || !strings.HasPrefix(long, "muteiny-studio-w-") {
+		t.Errorf("long host name: ClientID = %q", long)
+	}
+	if other := ClientID("muteiny-", "studio-workstation-downstairs"); other == long {
+		t.Errorf("host names with the same start share the client ID %q", other)
+	}
+	if id := ClientID("muteiny-", "ΣΣΣΣΣΣΣΣΣΣΣΣ"); len(id) > MaxClientIDLength || !utf8.ValidString(id) {
+		t.Errorf("multibyte host name: ClientID = %q", id)
+	}
+}
+
+func TestConnectPacket(t *testing.T) {
+	packet := ConnectPacket(Options{
+		ClientID:  "muteiny-test",
+		Username:  "user",
+		Password:  "secret",
+		KeepAlive: 30 * time.Second,
+		Will:      &Message{Topic: "muteiny/availability", Payload: []byte("offline"), QoS: 1, Retain: true},
+	})
+	want := appendString(nil, "MQTT")
+	want = append(want, protocolLevel, 0x80|0x40|0x20|0x08|0x04|0x02)
+	want = appendUint16(want, 30)
+	want = appendString(want, "muteiny-test")
+	want = appendString(want, "muteiny/availability")
+	want = appendString(want, "offline")
+	want = appendString(want, "user")
+	want = appendString(want, "secret")
+	if packet.Type != CONNECT || !bytes.Equal(packet.Body, want) {
+		t.Fatalf("CONNECT = %x, want %x", packet.Body, want)
+	}
+
+	//? A password without a user name is not allowed on the wire
+	packet = ConnectPacket(Options{ClientID: "c", Password: "secret"})
+	if flags := packet.Body[7]; flags&0x40 != 0 {
+		t.Fatalf("password flag set without a user name, flags %08b", flags)
+	}
+	if bytes.Contains(packet.Body, []byte("secret")) {
+		t.Fatal("password sent without a user name")
+	}
+}
+
+func TestPublishPacket(t *testing.T) {
+	for _, message := range []Message{
+		{Topic: "a/b", Payload: []byte("open"), QoS: 1, Retain: true},
+		{Topic: "a", Payload: []byte{}, QoS: 0},
+	} {
+		got, packetID, err := ParsePublish(PublishPacket(message, 42))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Topic != message.Topic || !bytes.Equal(got.Payload, message.Payload) || got.QoS != message.QoS || got.Retain != message.Retain {
+			t.Fatalf("parsed %+v, want %+v", got, message)
+		}
+		if message.QoS > 0 && packetID != 42 {
+			t.Fatalf("packet id = %d, want 42", packetID)
+		}
+	}
+	if _, _, err := ParsePublish(Packet{Type: PUBLISH, Flags: 0x02, Body: appendString(nil, "a")}); err == nil {
+		t.Fatal("QoS 1 publish without a packet id parsed")
+	}
+}
+
+func TestConnectRefusesPasswordWithoutUser(t *testing.T) {
+	broker := newFakeBroker(t)
+	if _, err := Connect(broker.URL(), Options{ClientID: "c", Password: "secret"}, nil); err == nil {
+		t.Fatal("connected with a password and no user name")
+	}
+	select {
+	case <-broker.got:
+		t.Fatal("the broker got a CONNECT")
+	default:
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	broker := newFakeBroker(t)
+	broker.connack = 4
+	_, err := Connect(broker.URL(), Options{ClientID: "c", Username: "user", Password: "wrong"}, nil)
+	var connackErr ConnackError
+	if !errors.As(err, &connackErr) || connackErr != 4 {
+		t.Fatalf("error = %v, want bad user name or password", err)
+	}
+}
+
+func TestConnectUnsupportedScheme(t *testing.T) {
+	if _, err := Connect("http://localhost:1883", Options{}, nil); err == nil {
+		t.Fatal("connected over http")
+	}
+}
+
+func TestPublishAndSubscribe(t *testing.T) {
+	broker := newFakeBroker(t)
+	messages := make(chan Message, 1)
+	client, err := Connect(broker.URL(), Options{ClientID: "muteiny-test", Username: "user", Password: "pw"}, func(message Message) { messages <- message })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+	connect := broker.Next(t, CONNECT)
+	if !bytes.Contains(connect.Body, []byte("muteiny-test")) {
+		t.Fatal("CONNECT without the client id")
+	}
+
+	if err := client.Publish("muteiny/state", []byte("open"), 1, true); err != nil {
+		t.Fatal(err)
+	}
+	message, _, err := ParsePublish(broker.Next(t, PUBLISH))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Topic != "muteiny/state" || string(message.Payload) != "open" || message.QoS != 1 || !message.Retain {
+		t.Fatalf("broker got %+v", message)
+	}
+	if err := client.Publish("muteiny/state", []byte("muted"), 0, false); err != nil {
+		t.Fatal(err)
+	}
+	broker.Next(t, PUBLISH)
+	if err := client.Publish("muteiny/state", nil, 2, false); err == nil {
+		t.Fatal("QoS 2 publish accepted")
+	}
+
+	if err := client.Subscribe("muteiny/command", 1); err != nil {
+		t.Fatal(err)
+	}
+	broker.Next(t, SUBSCRIBE)
+	if err := client.Subscribe("forbidden/command", 1); err == nil {
+		t.Fatal("refused subscription succeeded")
+	}
+
+	broker.Send(t, PublishPacket(Message{Topic: "muteiny/command", Payload: []byte("toggle"), QoS: 1}, 9))
+	select {
+	case message := <-messages:
+		if message.Topic != "muteiny/command" || string(message.Payload) != "toggle" {
+			t.Fatalf("client got %+v", message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message delivered")
+	}
+	//? QoS 1 messages are acknowledged with their packet id
+	puback := broker.Next(t, PUBACK)
+	if !bytes.Equal(puback.Body, []byte{0, 9}) {
+		t.Fatalf("PUBACK = %x, want 0009", puback.Body)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	broker := newFakeBroker(t)
+	client, err := Connect(broker.URL(), Options{ClientID: "c"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	broker.Next(t, DISCONNECT)
+	select {
+	case <-client.Done():
+	default:
+		t.Fatal("Done not closed after Disconnect")
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	broker := newFakeBroker(t)
+	broker.noAck = true
+	client, err := Connect(broker.URL(), Options{ClientID: "c"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker.Next(t, CONNECT)
+	published := make(chan error)
+	go func() { published <- client.Publish("t", []byte("x"), 1, false) }()
+	broker.Next(t, PUBLISH)
+	broker.Drop()
+	select {
+	case err := <-published:
+		if err == nil {
+			t.Fatal("publish acknowledged by a lost connection")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish still waiting after the connection was lost")
+	}
+	select {
+	case <-client.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done not closed after the connection was lost")
+	}
+	if err := client.Publish("t", []byte("x"), 1, false); err == nil {
+		t.Fatal("publish on a lost connection succeeded")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	broker := newFakeBroker(t)
+	client, err := Connect(broker.URL(), Options{ClientID: "c", KeepAlive: 20 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+	broker.Next(t, PINGREQ)
+	broker.Next(t, PINGREQ)
+}
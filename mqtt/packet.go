@@ -0,0 +1,217 @@
+// Package mqtt is a small MQTT 3.1.1 client, enough to publish retained state and receive commands
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Control packet types, the high nibble of the first byte
+const (
+	CONNECT    = 1
+	CONNACK    = 2
+	PUBLISH    = 3
+	PUBACK     = 4
+	SUBSCRIBE  = 8
+	SUBACK     = 9
+	PINGREQ    = 12
+	PINGRESP   = 13
+	DISCONNECT = 14
+)
+
+// Protocol level of MQTT 3.1.1
+const protocolLevel = 4
+
+// Largest remaining length the variable length encoding holds
+const maxRemainingLength = 268435455
+
+// Largest packet the client reads, state and commands are tiny
+const MaxPacketSize = 256 * 1024
+
+// Packet is a control packet with its fixed header split out
+type Packet struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+// Message is an application message
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// ConnackError is the refusal of a CONNECT by the broker
+type ConnackError byte
+
+func (e ConnackError) Error() string {
+	switch e {
+	case 1:
+		return "mqtt: unacceptable protocol version"
+	case 2:
+		return "mqtt: client identifier rejected"
+	case 3:
+		return "mqtt: server unavailable"
+	case 4:
+		return "mqtt: bad user name or password"
+	case 5:
+		return "mqtt: not authorized"
+	}
+	return fmt.Sprintf("mqtt: connection refused with code %d", byte(e))
+}
+
+// WritePacket writes a packet with its remaining length
+func WritePacket(w io.Writer, packet Packet) error {
+	if len(packet.Body) > maxRemainingLength {
+		return errors.New("mqtt: packet too large")
+	}
+	header := []byte{packet.Type<<4 | packet.Flags&0x0f}
+	length := len(packet.Body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		header = append(header, digit)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(header, packet.Body...))
+	return err
+}
+
+// ReadPacket reads one packet
+func ReadPacket(r *bufio.Reader) (Packet, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return Packet{}, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return Packet{}, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return Packet{}, errors.New("mqtt: malformed remaining length")
+		}
+		multiplier *= 128
+	}
+	if length > MaxPacketSize {
+		return Packet{}, fmt.Errorf("mqtt: packet of %d bytes is too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Packet{}, err
+	}
+	return Packet{Type: first >> 4, Flags: first & 0x0f, Body: body}, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = appendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// readString reads a length prefixed string and returns the rest
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("mqtt: truncated string")
+	}
+	length := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+length {
+		return "", nil, errors.New("mqtt: truncated string")
+	}
+	return string(b[2 : 2+length]), b[2+length:], nil
+}
+
+// ConnectPacket builds a CONNECT packet
+func ConnectPacket(options Options) Packet {
+	var flags byte = 0x02 // Clean session
+	if options.Will != nil {
+		flags |= 0x04 | (options.Will.QoS&0x03)<<3
+		if options.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	//? MQTT 3.1.1 forbids a password without a user name, Connect refuses the options
+	if options.Password != "" && options.Username != "" {
+		flags |= 0x40
+	}
+	if options.Username != "" {
+		flags |= 0x80
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, protocolLevel, flags)
+	body = appendUint16(body, uint16(options.KeepAlive.Seconds()))
+	body = appendString(body, options.ClientID)
+	if options.Will != nil {
+		body = appendString(body, options.Will.Topic)
+		body = appendBytes(body, options.Will.Payload)
+	}
+	if options.Username != "" {
+		body = appendString(body, options.Username)
+	}
+	if options.Password != "" && options.Username != "" {
+		body = appendString(body, options.Password)
+	}
+	return Packet{Type: CONNECT, Body: body}
+}
+
+// PublishPacket builds a PUBLISH packet, packetID is only sent for QoS 1 and 2
+func PublishPacket(message Message, packetID uint16) Packet {
+	flags := (message.QoS & 0x03) << 1
+	if message.Retain {
+		flags |= 0x01
+	}
+	body := appendString(nil, message.Topic)
+	if message.QoS > 0 {
+		body = appendUint16(body, packetID)
+	}
+	return Packet{Type: PUBLISH, Flags: flags, Body: append(body, message.Payload...)}
+}
+
+// ParsePublish reads the message and packet id of a PUBLISH packet
+func ParsePublish(packet Packet) (Message, uint16, error) {
+	message := Message{QoS: (packet.Flags >> 1) & 0x03, Retain: packet.Flags&0x01 != 0}
+	topic, rest, err := readString(packet.Body)
+	if err != nil {
+		return message, 0, err
+	}
+	message.Topic = topic
+	var packetID uint16
+	if message.QoS > 0 {
+		if len(rest) < 2 {
+			return message, 0, errors.New("mqtt: truncated publish")
+		}
+		packetID = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	message.Payload = rest
+	return message, packetID, nil
+}
+
+// SubscribePacket builds a SUBSCRIBE packet for one topic filter
+func SubscribePacket(topic string, qos byte, packetID uint16) Packet {
+	body := appendUint16(nil, packetID)
+	body = appendString(body, topic)
+	return Packet{Type: SUBSCRIBE, Flags: 0x02, Body: append(body, qos&0x03)}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"Muteiny/ipc"
+	"Muteiny/mqtt"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+var mqttBridge *MQTTBridge
+var mqttMenu *systray.MenuItem
+
+// Topic prefix Home Assistant reads discovery payloads from
+const haDiscoveryPrefix = "homeassistant"
+
+// Commands waiting to run, more are dropped
+const mqttCommandQueue = 16
+
+// Control methods the command topic accepts
+var mqttCommands = map[string]bool{"mute": true, "unmute": true, "toggle": true, "press": true, "release": true}
+
+// MQTTBridge publishes the mic state to a broker for on-air lights and home automation
+type MQTTBridge struct {
+	URL       string
+	Options   mqtt.Options
+	Topic     string // Base topic, the state is published to <Topic>/state
+	Discovery bool   // Publish Home Assistant discovery payloads
+	Commands  bool   // Run the control methods published to <Topic>/command
+	control   *ipc.Server
+
+	mu       sync.Mutex
+	status   string
+	changed  chan struct{}
+	commands chan string
+}
+
+func NewMQTTBridge(url, topic string) *MQTTBridge {
+	hostname, _ := os.Hostname()
+	b := &MQTTBridge{URL: url, Topic: strings.TrimSuffix(topic, "/"), control: NewControlServer(), status: "connecting", changed: make(chan struct{}, 1), commands: make(chan string, mqttCommandQueue)}
+	b.Options = mqtt.Options{
+		//? Long host names are cut and hashed, strict brokers refuse client IDs over 23 bytes
+		ClientID: mqtt.ClientID("muteiny-", hostname),
+		//? The broker tells everyone we are gone when the connection drops without a goodbye
+		Will: &mqtt.Message{Topic: b.topic("availability"), Payload: []byte("offline"), QoS: 1, Retain: true},
+	}
+	return b
+}
+
+func (b *MQTTBridge) topic(name string) string {
+	return b.Topic + "/" + name
+}
+
+// StateChanged is called after every event, the bridge reads the engine state when it publishes
+func (b *MQTTBridge) StateChanged() {
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Status is "connecting", "connected" or "disconnected"
+func (b *MQTTBridge) Status() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+func (b *MQTTBridge) setStatus(status string) {
+	b.mu.Lock()
+	b.status = status
+	b.mu.Unlock()
+	if mqttMenu != nil {
+		mqttMenu.SetTitle("MQTT: " + status)
+	}
+}
+
+// micState is the published state: open, muted, or disabled after a panic
+func micState(status Status) string {
+	switch {
+	case status.Panicked:
+		return "disabled"
+	case status.Open:
+		return "open"
+	}
+	return "muted"
+}
+
+// Run keeps a connection to the broker until stop is closed, reconnecting with backoff
+func (b *MQTTBridge) Run(stop <-chan struct{}) {
+	go b.runCommands(stop)
+	backoff := minRestartBackoff
+	for {
+		err := b.runConnection(stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err == nil {
+			backoff = minRestartBackoff
+		}
+		b.setStatus("disconnected")
+		if err != nil && backoff == minRestartBackoff {
+			fmt.Println("Error connecting to the MQTT broker", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		if err != nil {
+			backoff *= 2
+			if backoff > maxRestartBackoff {
+				backoff = maxRestartBackoff
+			}
+		}
+	}
+}
+
+// runConnection publishes the state until the connection is lost or stop is closed, nil means it connected before it ended
+func (b *MQTTBridge) runConnection(stop <-chan struct{}) error {
+	client, err := mqtt.Connect(b.URL, b.Options, b.handleMessage)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Connected to the MQTT broker at", b.URL)
+	b.setStatus("connected")
+
+	if b.Discovery {
+		if err := b.publishDiscovery(client); err != nil {
+			fmt.Println("Error publishing the Home Assistant discovery", err)
+		}
+	}
+	if err := client.Publish(b.topic("availability"), []byte("online"), 1, true); err != nil {
+		fmt.Println("Error publishing to MQTT", err)
+	}
+	if b.Commands {
+		if err := client.Subscribe(b.topic("command"), 1); err != nil {
+			fmt.Println("Error subscribing to the MQTT command topic", err)
+		}
+	}
+
+	//? Retained messages are only sent again when they change
+	lastState, lastDevice := "", ""
+	for {
+		status := engine.Status()
+		if state := micState(status); state != lastState {
+			if err := client.Publish(b.topic("state"), []byte(state), 1, true); err != nil {
+				fmt.Println("Error publishing to MQTT", err)
+			} else {
+				lastState = state
+			}
+		}
+		if status.Device != lastDevice {
+			if err := client.Publish(b.topic("device"), []byte(status.Device), 1, true); err != nil {
+				fmt.Println("Error publishing to MQTT", err)
+			} else {
+				lastDevice = status.Device
+			}
+		}
+		select {
+		case <-stop:
+			//? No acknowledgement to wait for, the DISCONNECT after it is what the broker needs to drop the will
+			client.Publish(b.topic("availability"), []byte("offline"), 0, true)
+			client.Disconnect()
+			return nil
+		case <-client.Done():
+			return nil
+		case <-b.changed:
+		}
+	}
+}
+
+// handleMessage runs a control method published to the command topic
+func (b *MQTTBridge) handleMessage(message mqtt.Message) {
+	if message.Topic != b.topic("command") {
+		return
+	}
+	method := strings.ToLower(strings.TrimSpace(string(message.Payload)))
+	if !mqttCommands[method] {
+		fmt.Println("Error unknown MQTT command", method)
+		return
+	}
+	//? Messages are read on the connection goroutine and the engine may block on COM, so they are queued
+	select {
+	case b.commands <- method:
+	default:
+		fmt.Println("Error MQTT command queue is full, dropping", method)
+	}
+}
+
+// runCommands runs the queued commands one at a time in the order they were published, until stop is closed
+func (b *MQTTBridge) runCommands(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case method := <-b.commands:
+			if _, err := b.control.Call(method, nil); err != nil {
+				fmt.Println("Error running MQTT command", method, err)
+			}
+		}
+	}
+}
+
+// publishDiscovery announces the mic to Home Assistant, as a switch when commands are enabled and a binary sensor otherwise
+func (b *MQTTBridge) publishDiscovery(client *mqtt.Client) error {
+	nodeID := regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(b.Options.ClientID, "_")
+	hostname, _ := os.Hostname()
+	device := map[string]interface{}{
+		"identifiers":  []string{nodeID},
+		"name":         "Muteiny " + hostname,
+		"manufacturer": "Muteiny",
+	}
+	entity := func(name, id string, fields map[string]interface{}) map[string]interface{} {
+		config := map[string]interface{}{
+			"name":               name,
+			"unique_id":          nodeID + "_" + id,
+			"availability_topic": b.topic("availability"),
+			"device":             device,
+		}
+		for key, value := range fields {
+			config[key] = value
+		}
+		return config
+	}
+	mic := map[string]interface{}{
+		"state_topic":    b.topic("state"),
+		"value_template": "{{ 'ON' if value == 'open' else 'OFF' }}",
+		"icon":           "mdi:microphone",
+	}
+	micComponent, staleComponent := "binary_sensor", "switch"
+	if b.Commands {
+		micComponent, staleComponent = "switch", "binary_sensor"
+		mic["command_topic"] = b.topic("command")
+		mic["payload_on"] = "unmute"
+		mic["payload_off"] = "mute"
+		mic["state_on"] = "ON"
+		mic["state_off"] = "OFF"
+	}
+	configs := map[string]interface{}{
+		micComponent + "/" + nodeID + "/mic":   entity("Microphone", "mic", mic),
+		"sensor/" + nodeID + "/state":          entity("Microphone State", "state", map[string]interface{}{"state_topic": b.topic("state"), "icon": "mdi:microphone-settings"}),
+		"sensor/" + nodeID + "/device":         entity("Microphone Device", "device", map[string]interface{}{"state_topic": b.topic("device"), "icon": "mdi:microphone-variant"}),
+		staleComponent + "/" + nodeID + "/mic": nil,
+	}
+	for path, config := range configs {
+		//? An empty retained config removes the entity of the other mode
+		payload := []byte{}
+		if config != nil {
+			var err error
+			if payload, err = json.Marshal(config); err != nil {
+				return err
+			}
+		}
+		if err := client.Publish(haDiscoveryPrefix+"/"+path+"/config", payload, 1, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunMQTT connects the bridge until the returned function is called, which publishes offline first
+func RunMQTT(bridge *MQTTBridge) func() {
+	Subscribe(func(event Event) {
+		bridge.StateChanged()
+	})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		bridge.Run(stop)
+		close(done)
+	}()
+	return func() {
+		close(stop)
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"Muteiny/icons"
+	"Muteiny/input"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/getlantern/systray"
@@ -21,47 +24,117 @@ import (
 // Reference to the input device menuitem to change the name of the selected input device
 var inputDeviceMenu *systray.MenuItem
 
+// Reference to the profile menuitem, changed by the control API
+var profileMenu *systray.MenuItem
+
 // Is systray active
 var systrayActive bool
 
 // Keep these as globals, simple program no real use to pass them around everywhere
 var keyboardFlag KeyboardFlag
+var deafenKeyFlag KeyboardFlag
+var deafenModeFlag = ChoiceFlag{Value: "hold", Choices: []string{"hold", "toggle"}}
+var panicKeyFlag KeyboardFlag
 var mouseDownFlag MouseFlag
 var mouseUpFlag MouseFlag
 var mouseData MouseFlag
 var holdFlag HoldFlag
+var maxOpenFlag IntFlag
+var reconcileFlag IntFlag
+var ipcFlag bool
+var ipcNameFlag = StringFlag{Value: "Muteiny"}
+var httpPortFlag IntFlag
+var httpTokenFlag StringFlag
+var httpOriginFlag StringFlag
+var mqttFlag, mqttUserFlag, mqttPasswordFlag, mqttClientIDFlag StringFlag
+var mqttTopicFlag = StringFlag{Value: "muteiny"}
+var mqttDiscoveryFlag, mqttCommandsFlag bool
+var onOpenFlag, onCloseFlag, onDeviceFlag, onProfileFlag StringFlag
+var scriptTimeoutFlag = IntFlag{Value: 10000}
+var scriptLimitFlag = IntFlag{Value: 4}
+var webhookFlag, webhookSecretFlag StringFlag
+var oscPortFlag IntFlag
+var oscBindFlag = StringFlag{Value: "127.0.0.1"}
+var oscFeedbackFlag StringFlag
+var oscPrefixFlag = StringFlag{Value: "/muteiny"}
+var obsFlag, obsPasswordFlag, obsInputFlag, obsSceneFlag, obsItemFlag, obsControlFlag StringFlag
+var muteModeFlag = ChoiceFlag{Value: "mute", Choices: []string{"mute", "volume", "fade"}}
+var fadeFlag = IntFlag{Value: 100}
+var recoverFlag = ChoiceFlag{Value: "restore", Choices: []string{"restore", "tray", "ignore"}}
+var startupFlag = ChoiceFlag{Value: "mute", Choices: []string{"mute", "keep", "open"}}
+var shutdownFlag = ChoiceFlag{Value: "restore", Choices: []string{"restore", "muted", "asis", "muteall"}}
+var profileFlag StringFlag
+var profilesPathFlag = StringFlag{Value: defaultProfilesPath()}
+var externalMuteFlag = ChoiceFlag{Value: "warn", Choices: []string{"reassert", "adopt", "warn"}}
+var silenceReleaseFlag bool
+var silenceThresholdFlag = FloatFlag{Value: 0.02}
+var silenceTimeFlag = IntFlag{Value: 200}
+var voiceFlag bool
+var voiceAttackFlag = FloatFlag{Value: 0.1}
+var voiceReleaseFlag = FloatFlag{Value: 0.05}
+var voiceReleaseTimeFlag = IntFlag{Value: 400}
+var voicePTTFlag = ChoiceFlag{Value: "open", Choices: []string{"open", "mute"}}
+var mutedWarningFlag bool
+var mutedWarningThresholdFlag = FloatFlag{Value: 0.15}
+var mutedWarningSustainFlag = IntFlag{Value: 700}
+var mutedWarningCooldownFlag = IntFlag{Value: 30}
+var mutedWarningSoundFlag bool
+var cuesFlag bool
+var cueOpenFreqFlag = FloatFlag{Value: 880}
+var cueCloseFreqFlag = FloatFlag{Value: 440}
+var cueDurationFlag = IntFlag{Value: 60}
+var cueVolumeFlag = FloatFlag{Value: 0.3}
+var cueOpenWavFlag StringFlag
+var cueCloseWavFlag StringFlag
+var duckFlag IntFlag
+var duckAppsFlag StringFlag
+var gainFlag StringFlag
+var gainResetFlag bool
 var bindMode bool
 
 // queue of work to run in main thread.
 var mainfunc = make(chan func())
 
+// Closed by exit, the main thread then stops everything that queues work before it stops the queue
+var quit = make(chan struct{})
+var quitOnce sync.Once
+
+// Closed once the main thread no longer runs the queue
+var mainDone = make(chan struct{})
+
 // do runs f on the main thread.
 func do(f func()) {
 	done := make(chan bool, 1)
-	mainfunc <- func() {
+	select {
+	case mainfunc <- func() {
 		f()
 		done <- true
+	}:
+		<-done
+	case <-mainDone:
+		//? Shutting down, the shutdown policy decides the final mute state
 	}
-	<-done
 }
 
 func main() {
-	// ? This is a mutex to prevent multiple instances of the program from running at the same time.
-	closeMutex := InstanceMutex()
-	defer closeMutex()
-
-	// Store the original mute state of the devices
-	deviceStatesMap := make(map[string]bool)
-
 	// ? Set the flags
 	log.SetFlags(0)
 	log.SetPrefix("error: ")
 
+	// Stops following the default capture device and the voice gate, replaced once the mute mode is running
+	stopWatching := func() {}
+
 	// * Load the args
 	f := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	// * Keyboard
 	f.Var(&keyboardFlag, "keybind", "Specify keybind in format VK_A")
 	f.Var(&keyboardFlag, "k", "Alias of -keybind")
+	// * Deafen
+	f.Var(&deafenKeyFlag, "deafenkey", "Specify a keybind in format VK_A that mutes the output device together with the mic")
+	f.Var(&deafenKeyFlag, "dk", "Alias of -deafenkey")
+	f.Var(&deafenModeFlag, "deafenmode", "How -deafenkey works: hold deafens while held, toggle deafens until pressed again (default hold)")
+	f.Var(&panicKeyFlag, "panickey", "Specify a keybind in format VK_A that mutes every capture device and disables push-to-talk until pressed again or re-armed from the tray")
+	f.Var(&panicKeyFlag, "pk", "Alias of -panickey")
 	// * Mouse
 	f.Var(&mouseDownFlag, "mousedown", "Specify mouse keybind in format 523 (down) !set both mouse up and down for it to work!")
 	f.Var(&mouseDownFlag, "md", "Alias of -mousedown")
@@ -72,14 +145,147 @@ func main() {
 	// * Hold time
 	f.Var(&holdFlag, "holdtime", "Specify the time in milliseconds to keep the mic open after release (default 500)")
 	f.Var(&holdFlag, "h", "Alias of -holdtime")
+	f.Var(&maxOpenFlag, "maxopen", "Specify the time in seconds after which a mic that stayed open is closed, for when a key release gets lost, 0 disables it (default 0)")
+	f.Var(&reconcileFlag, "reconcile", "Specify how often in milliseconds to check that a held binding is still physically down and release it if not, 0 disables it (default 0)")
+	f.BoolVar(&silenceReleaseFlag, "silencerelease", false, "Keep the mic open after release until the input has been below -silencethreshold for -silencetime, -holdtime becomes the maximum")
+	f.Var(&silenceThresholdFlag, "silencethreshold", "Specify the peak level from 0 to 1 that counts as silence for -silencerelease (default 0.02)")
+	f.Var(&silenceTimeFlag, "silencetime", "Specify the time in milliseconds the input has to be silent for -silencerelease (default 200)")
+	// * Voice activation
+	f.BoolVar(&voiceFlag, "voice", false, "Open the mic when the input level crosses -voiceattack, the bindings force the mic open or muted depending on -voiceptt")
+	f.Var(&voiceAttackFlag, "voiceattack", "Specify the peak level from 0 to 1 that opens the mic in voice mode (default 0.1)")
+	f.Var(&voiceReleaseFlag, "voicerelease", "Specify the peak level from 0 to 1 the input has to stay below to close the mic in voice mode (default 0.05)")
+	f.Var(&voiceReleaseTimeFlag, "voicereleasetime", "Specify the time in milliseconds the input has to stay below -voicerelease to close the mic (default 400)")
+	f.Var(&voicePTTFlag, "voiceptt", "What the bindings do in voice mode: open forces the mic open, mute forces it muted (default open)")
+	// * Talking while muted warning
+	f.BoolVar(&mutedWarningFlag, "mutedwarning", false, "Warn with a notification and a flashing tray icon when you talk while the mic is muted")
+	f.Var(&mutedWarningThresholdFlag, "mutedthreshold", "Specify the peak level from 0 to 1 that counts as talking for -mutedwarning (default 0.15)")
+	f.Var(&mutedWarningSustainFlag, "mutedsustain", "Specify the time in milliseconds you have to talk before -mutedwarning warns (default 700)")
+	f.Var(&mutedWarningCooldownFlag, "mutedcooldown", "Specify the minimum time in seconds between two -mutedwarning warnings (default 30)")
+	f.BoolVar(&mutedWarningSoundFlag, "mutedsound", false, "Play a warning sound with -mutedwarning")
+	// * Audible cues
+	f.BoolVar(&cuesFlag, "cues", false, "Play a short sound when the mic opens and closes")
+	f.Var(&cueOpenFreqFlag, "cueopenfreq", "Specify the frequency in Hz of the open tone (default 880)")
+	f.Var(&cueCloseFreqFlag, "cueclosefreq", "Specify the frequency in Hz of the close tone (default 440)")
+	f.Var(&cueDurationFlag, "cueduration", "Specify the length in milliseconds of the tones (default 60)")
+	f.Var(&cueVolumeFlag, "cuevolume", "Specify the volume from 0 to 1 of the tones (default 0.3)")
+	f.Var(&cueOpenWavFlag, "cueopenwav", "Specify a PCM wav file to play instead of the open tone")
+	f.Var(&cueCloseWavFlag, "cueclosewav", "Specify a PCM wav file to play instead of the close tone")
+	// * Output ducking
+	f.Var(&duckFlag, "duck", "Lower the output volume by this percentage while the mic is open, 0 disables ducking (default 0)")
+	f.Var(&duckAppsFlag, "duckapps", "Specify a comma separated list of processes like game.exe to duck instead of the whole output device")
+	// * Capture gain
+	f.Var(&gainFlag, "gain", "Specify the capture level set when the mic opens, a scalar like 0.8 or decibels like -6dB, overrides the gain of the profile")
+	f.BoolVar(&gainResetFlag, "gainreset", false, "Put the original capture level back when the mic closes, used with -gain")
+	// * Mute mode
+	f.Var(&muteModeFlag, "mutemode", "How to silence the mic: mute sets the mute flag, volume sets the capture level to 0, fade ramps the capture level (default mute)")
+	f.Var(&muteModeFlag, "mm", "Alias of -mutemode")
+	f.Var(&fadeFlag, "fadetime", "Specify the time in milliseconds a fade takes in -mutemode fade (default 100)")
+	f.Var(&fadeFlag, "ft", "Alias of -fadetime")
+	// * External mute changes
+	f.Var(&externalMuteFlag, "externalmute", "What to do when another program changes the mute state of the mic: reassert, adopt or warn (default warn)")
+	f.Var(&externalMuteFlag, "em", "Alias of -externalmute")
+	// * Profiles
+	f.Var(&profileFlag, "profile", "Specify the profile to use from the profiles file")
+	f.Var(&profileFlag, "p", "Alias of -profile")
+	f.Var(&profilesPathFlag, "profiles", "Specify the path of the profiles file (default %AppData%\\Muteiny\\profiles.json)")
+	// * Startup and shutdown
+	f.Var(&startupFlag, "startup", "What to do with the mic on startup: mute, keep or open (default mute)")
+	f.Var(&shutdownFlag, "shutdown", "What to do with the mics on shutdown: restore, muted, asis or muteall (default restore)")
+	// * Crash recovery
+	f.Var(&recoverFlag, "recover", "What to do with the original mute states of a session that didn't shut down cleanly: restore, tray or ignore (default restore)")
+	// * Control API
+	f.BoolVar(&ipcFlag, "ipc", true, "Let other programs control Muteiny over a local named pipe with JSON-RPC")
+	f.Var(&ipcNameFlag, "ipcname", "Specify the name of the control pipe (default Muteiny)")
+	// * Status API
+	f.Var(&httpPortFlag, "httpport", "Serve the HTTP and WebSocket status API on this localhost port, 0 disables it (default 0)")
+	f.Var(&httpOriginFlag, "httporigin", "Specify a comma separated list of web page origins allowed to use the status API, like http://localhost:3000, null allows pages opened from files")
+	f.Var(&httpTokenFlag, "httptoken", "Specify the token the status API requires as a Bearer token or ?token=")
+	// * OBS
+	f.Var(&obsFlag, "obs", "Connect to obs-websocket 5 at this URL, like ws://127.0.0.1:4455")
+	f.Var(&obsPasswordFlag, "obspassword", "Specify the obs-websocket server password")
+	f.Var(&obsInputFlag, "obsinput", "Specify the OBS input muted while the mic is closed")
+	f.Var(&obsSceneFlag, "obsscene", "Specify the OBS scene of -obsitem")
+	f.Var(&obsItemFlag, "obsitem", "Specify the OBS scene item shown while the mic is open, used with -obsscene")
+	f.Var(&obsControlFlag, "obscontrol", "Specify an OBS input whose mute state opens and closes the mic, bind its OBS mute hotkeys to drive Muteiny from OBS")
+	// * Scripts
+	f.Var(&onOpenFlag, "onopen", "Specify a command to run when the mic opens, it gets the event as MUTEINY_ environment variables and as JSON on stdin")
+	f.Var(&onCloseFlag, "onclose", "Specify a command to run when the mic closes")
+	f.Var(&onDeviceFlag, "ondevice", "Specify a command to run when the default capture device changes")
+	f.Var(&onProfileFlag, "onprofile", "Specify a command to run when the profile changes")
+	f.Var(&scriptTimeoutFlag, "scripttimeout", "Specify the time in milliseconds a script may run before it is killed (default 10000)")
+	f.Var(&scriptLimitFlag, "scriptlimit", "Specify how many scripts may run at the same time, later events wait for a free slot (default 4)")
+	// * Webhooks
+	f.Var(&webhookFlag, "webhook", "Post every event as JSON to this URL, more targets with filters go in the profiles file")
+	f.Var(&webhookSecretFlag, "webhooksecret", "Specify the key that signs the -webhook payloads")
+	// * MQTT
+	f.Var(&mqttFlag, "mqtt", "Publish the mic state to the MQTT broker at this URL, like tcp://localhost:1883 or ssl://broker:8883")
+	f.Var(&mqttUserFlag, "mqttuser", "Specify the MQTT user name")
+	f.Var(&mqttPasswordFlag, "mqttpassword", "Specify the MQTT password, it needs -mqttuser")
+	f.Var(&mqttClientIDFlag, "mqttclientid", "Specify the MQTT client ID, brokers only have to accept up to 23 characters (default muteiny- and the host name)")
+	f.Var(&mqttTopicFlag, "mqtttopic", "Specify the base topic the state, device and availability are published under (default muteiny)")
+	f.BoolVar(&mqttDiscoveryFlag, "mqttdiscovery", true, "Publish Home Assistant discovery payloads")
+	f.BoolVar(&mqttCommandsFlag, "mqttcommands", false, "Run mute, unmute, toggle, press and release published to the command topic")
+	// * OSC
+	f.Var(&oscBindFlag, "oscbind", "Specify the address -oscport listens on, 0.0.0.0 lets devices on the network reach it (default 127.0.0.1)")
+	f.Var(&oscPortFlag, "oscport", "Listen for OSC on this UDP port, <prefix>/ptt 1|0 presses and releases, <prefix>/toggle, /mute and /unmute (default 0, off)")
+	f.Var(&oscFeedbackFlag, "oscfeedback", "Specify a comma separated list of host:port targets that get <prefix>/open 1|0 and <prefix>/state on every change")
+	f.Var(&oscPrefixFlag, "oscprefix", "Specify the OSC address prefix (default /muteiny)")
 	// * Bind mode
 	f.BoolVar(&bindMode, "keybindmode", false, "Set the program to bind mode, this will not mute the mic but instead write the binds to the console/binds.log to help you find the correct VK/Mouse codes")
 	f.Parse(os.Args[1:])
 
+	// ? A command like `muteiny toggle` is sent to the running instance
+	if f.NArg() > 0 {
+		os.Exit(ForwardCommand(ipcNameFlag.Value, f.Args()))
+	}
+
+	// ? This is a mutex to prevent multiple instances of the program from running at the same time.
+	closeMutex := InstanceMutex()
+	defer closeMutex()
+
+	if voiceFlag && voiceReleaseFlag.Value > voiceAttackFlag.Value {
+		log.Fatal("-voicerelease must not be higher than -voiceattack")
+	}
+	if cueDurationFlag.Value <= 0 {
+		log.Fatal("-cueduration must be positive")
+	}
+	if cueVolumeFlag.Value < 0 || cueVolumeFlag.Value > 1 {
+		log.Fatal("-cuevolume must be between 0 and 1")
+	}
+	if duckFlag.Value < 0 || duckFlag.Value > 100 {
+		log.Fatal("-duck must be between 0 and 100")
+	}
+	if gainFlag.IsSet {
+		if _, err := ParseGainLevel(gainFlag.Value); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !obsFlag.IsSet && (obsInputFlag.IsSet || obsItemFlag.IsSet || obsControlFlag.IsSet) {
+		log.Fatal("The OBS options need -obs")
+	}
+	if scriptTimeoutFlag.Value <= 0 || scriptLimitFlag.Value <= 0 {
+		log.Fatal("-scripttimeout and -scriptlimit must be positive")
+	}
+	if mqttPasswordFlag.IsSet && !mqttUserFlag.IsSet {
+		log.Fatal("-mqttpassword needs -mqttuser")
+	}
+	if mqttClientIDFlag.IsSet && mqttClientIDFlag.Value == "" {
+		log.Fatal("-mqttclientid must not be empty")
+	}
+	if obsItemFlag.IsSet != obsSceneFlag.IsSet {
+		log.Fatal("-obsitem and -obsscene must be used together")
+	}
+	if obsControlFlag.IsSet && obsControlFlag.Value == obsInputFlag.Value {
+		//? Mirroring onto the control input would feed every change back
+		log.Fatal("-obscontrol must not be the -obsinput")
+	}
+
 	if bindMode {
 		fmt.Println("Bind mode active")
 		// ? Set the flags to false so the program doesn't run the mute mode
 		keyboardFlag.IsSet = false
+		deafenKeyFlag.IsSet = false
+		panicKeyFlag.IsSet = false
 		mouseUpFlag.IsSet = false
 		mouseDownFlag.IsSet = false
 		mouseData.IsSet = false
@@ -92,86 +298,252 @@ func main() {
 			holdFlag.Set("500")
 		}
 
+		if err := LoadProfiles(profilesPathFlag.Value); err != nil {
+			log.Fatal(err)
+		}
+		if err := SetProfile(profileFlag.Value); err != nil {
+			log.Fatal(err)
+		}
+
+		if onOpenFlag.IsSet || onCloseFlag.IsSet || onDeviceFlag.IsSet || onProfileFlag.IsSet {
+			commands := map[string]string{"open": onOpenFlag.Value, "close": onCloseFlag.Value, "device": onDeviceFlag.Value, "profile": onProfileFlag.Value}
+			scripts := NewScriptHooks(commands, time.Duration(scriptTimeoutFlag.Value)*time.Millisecond, scriptLimitFlag.Value)
+			Subscribe(scripts.Handle)
+		}
+		if cuesFlag {
+			cues, err := NewCues(&WinMMPlayer{})
+			if err != nil {
+				log.Fatal(err)
+			}
+			Subscribe(func(event Event) {
+				cues.Handle(event.Type)
+			})
+		}
+		if duckFlag.Value > 0 {
+			ducker = NewDucker(duckFlag.Value, duckAppsFlag.Value)
+			Subscribe(func(event Event) {
+				if event.Type == "open" || event.Type == "close" {
+					ducker.StateChanged(event.Open)
+				}
+			})
+		}
+
 		// Initialize OLE for this thread
 		InitOLE()
 
+		//? Whatever a previous session left behind must be restored before we take the snapshot
+		if err := LoadState(); err != nil {
+			fmt.Println("Error loading state file", err)
+		}
+		RestoreSavedVolumes()
+		RestoreAllGain()
+		RestoreSavedRenderDevices()
+		RestoreSavedDucking()
+		RecoverPreviousSession()
+		muteStrategy = NewMuteStrategy(muteModeFlag.Value)
+
 		// ? Get all the devices and their mute state
 		devices, releaseAll := GetAllDevices()
 		for k, v := range devices {
 			mute := GetMute(v)
+			//? The current state of a device left muted by a crash is not its original state
+			if original, ok := previousSession[k]; ok {
+				mute = original
+			}
 			fmt.Printf("Device: %s Muted: %t\n", k, mute)
-			deviceStatesMap[k] = mute
+			RememberOriginalMute(k, mute)
 		}
 		releaseAll()
 		//? Fetch the default communications device
-		aev, release := GetDefaultDevice()
+		aev, deviceName, release := GetDefaultDevice()
+		engine.InitDevice(deviceName)
 
-		if !GetMute(aev) { //? Only call mute if the device is not muted
-			if err := SetMute(aev, true); err != nil {
-				fmt.Println("Error setting startup mute state", err)
-				return
-			}
+		if err := ApplyStartupPolicy(deviceName, aev); err != nil {
+			fmt.Println("Error setting startup mute state", err)
+			return
 		}
 		release()
 		ole.CoUninitialize()
 
+		//? Follow the default capture device so the mic state moves with it
+		engine.WatchMute()
+		stopWatchingDevice := WatchDefaultDevice(engine.DefaultDeviceChanged)
+		stopVoice := func() {}
+		if voiceFlag {
+			fmt.Println("Voice mode active")
+			stopVoice = engine.RunVoiceActivation()
+		}
+		stopMutedWarning := func() {}
+		if mutedWarningFlag {
+			stopMutedWarning = engine.RunMutedWarning()
+		}
+		stopReconciler := func() {}
+		if reconcileFlag.Value > 0 {
+			stopReconciler = runReconciler()
+		}
+		stopWatching = func() {
+			stopReconciler()
+			stopMutedWarning()
+			stopVoice()
+			stopWatchingDevice()
+			engine.StopWatchingMute()
+		}
+
+		//? The hooks are supervised, a hook that fails or that Windows removed is installed again
+		stopInput := make(chan struct{})
 		if mouseDownFlag.IsSet && mouseUpFlag.IsSet {
 			fmt.Println("Mouse mode active")
-			go func() {
-				InitOLE()
-				if err := runMouse(mouseDownFlag.Value, mouseUpFlag.Value); err != nil { //? Mouse3 Down: 523, Mouse3 Up: 524
-					log.Fatal(err)
-				}
-				ole.CoUninitialize()
-			}()
+			reportHookHealth("Mouse Hook", "starting")
+			go hookSupervisor.Run(input.Source{
+				Name: "Mouse Hook",
+				Run: func(stop <-chan struct{}, alive func()) error {
+					InitOLE()
+					defer ole.CoUninitialize()
+					return runMouse(mouseDownFlag.Value, mouseUpFlag.Value, stop, alive) //? Mouse3 Down: 523, Mouse3 Up: 524
+				},
+				Heartbeat: &input.Heartbeat{State: AsyncKeyState{}, Keys: mouseButtonKeys, Grace: 100 * time.Millisecond},
+			}, stopInput)
 		}
 
-		if keyboardFlag.IsSet {
+		if keyboardFlag.IsSet || deafenKeyFlag.IsSet || panicKeyFlag.IsSet {
 			fmt.Println("Keyboard mode active")
-			go func() {
-				InitOLE()
-				if err := runKeyboard(keyboardFlag.Value); err != nil {
-					log.Fatal(err)
-				}
-				ole.CoUninitialize()
-			}()
+			reportHookHealth("Keyboard Hook", "starting")
+			go hookSupervisor.Run(input.Source{
+				Name: "Keyboard Hook",
+				Run: func(stop <-chan struct{}, alive func()) error {
+					InitOLE()
+					defer ole.CoUninitialize()
+					return runKeyboard(keyboardFlag.Value, deafenKeyFlag.Value, panicKeyFlag.Value, stop, alive)
+				},
+				Heartbeat: &input.Heartbeat{State: AsyncKeyState{}, Keys: keyboardKeys(), Grace: 100 * time.Millisecond},
+			}, stopInput)
+		}
+		stopControl := func() {}
+		if ipcFlag {
+			stop, err := RunControlServer(ipcNameFlag.Value)
+			if err != nil {
+				fmt.Println("Error starting the control API", err)
+			} else {
+				stopControl = stop
+			}
+		}
+		stopStatusAPI := func() {}
+		if httpPortFlag.Value > 0 {
+			stop, err := RunStatusAPI(httpPortFlag.Value, httpTokenFlag.Value, httpOriginFlag.Value)
+			if err != nil {
+				fmt.Println("Error starting the status API", err)
+			} else {
+				stopStatusAPI = stop
+			}
+		}
+		if obsFlag.IsSet {
+			obsBridge = NewOBSBridge(obsFlag.Value, obsPasswordFlag.Value)
+			obsBridge.Input = obsInputFlag.Value
+			obsBridge.Scene = obsSceneFlag.Value
+			obsBridge.Item = obsItemFlag.Value
+			obsBridge.Control = obsControlFlag.Value
+			obsBridge.StateChanged(engine.IsOpen())
+			Subscribe(func(event Event) {
+				obsBridge.StateChanged(event.Open)
+			})
+			go obsBridge.Run(stopInput)
+		}
+		stopMQTT := func() {}
+		if mqttFlag.IsSet {
+			mqttBridge = NewMQTTBridge(mqttFlag.Value, mqttTopicFlag.Value)
+			mqttBridge.Options.Username = mqttUserFlag.Value
+			mqttBridge.Options.Password = mqttPasswordFlag.Value
+			if mqttClientIDFlag.IsSet {
+				mqttBridge.Options.ClientID = mqttClientIDFlag.Value
+			}
+			mqttBridge.Discovery = mqttDiscoveryFlag
+			mqttBridge.Commands = mqttCommandsFlag
+			stopMQTT = RunMQTT(mqttBridge)
+		}
+		stopWebhooks := StartWebhooks()
+		stopOSC := func() {}
+		if oscPortFlag.Value > 0 || oscFeedbackFlag.IsSet {
+			stop, err := RunOSC(oscBindFlag.Value, oscPortFlag.Value, oscPrefixFlag.Value, oscFeedbackFlag.Value)
+			if err != nil {
+				fmt.Println("Error starting OSC", err)
+			} else {
+				stopOSC = stop
+			}
+		}
+		stopEngine := stopWatching
+		stopWatching = func() {
+			stopOSC()
+			stopWebhooks()
+			stopMQTT()
+			stopStatusAPI()
+			stopControl()
+			close(stopInput)
+			stopEngine()
 		}
 	}
 
 	go systray.Run(onReady, nil)
 
-	for f := range mainfunc {
-		f()
+	//? The producers may be waiting on the queue while they stop, so it keeps running until they are gone
+	stopped := make(chan struct{})
+	go func() {
+		<-quit
+		stopWatching()
+		close(stopped)
+	}()
+	for running := true; running; {
+		select {
+		case f := <-mainfunc:
+			f()
+		case <-stopped:
+			running = false
+		}
 	}
+	close(mainDone)
 
 	if !bindMode {
 		InitOLE()
-		// Restore the original mute state of the devices
-		fmt.Println("Setting mute to original state before shutdown!")
-		devices, releaseAll := GetAllDevices()
-		for deviceName, muteState := range deviceStatesMap {
-			if usedDevices[deviceName] {
-				if devices[deviceName] != nil {
-					fmt.Println("Restoring mute state for:", deviceName, "to:", muteState)
-					if muteState != GetMute(devices[deviceName]) { //? Only set the mute state if it's different from current state
-						if err := SetMute(devices[deviceName], muteState); err != nil {
-							fmt.Println("Error setting mute state for:", deviceName, err)
-						}
-					}
-				} else {
-					fmt.Println("Device not found:", deviceName)
-				}
-			}
-		}
-		releaseAll()
+		ApplyShutdownPolicy()
+		//? Clean shutdown, there is nothing left for the next launch to recover
+		UpdateState(func(state *SavedState) {
+			state.MuteStates = make(map[string]bool)
+			state.UsedDevices = make(map[string]bool)
+		})
 		ole.CoUninitialize()
 	}
 }
 
+// runReconciler releases bindings whose up event got lost, returns a function that stops it
+func runReconciler() func() {
+	reconciler := &input.Reconciler{
+		State:   AsyncKeyState{},
+		IsHeld:  engine.IsHeld,
+		Release: engine.Release,
+		Misses:  2,
+	}
+	if keyboardFlag.IsSet {
+		if vk, err := keyCode(keyboardFlag.Value); err != nil {
+			fmt.Println("Not reconciling the keybind:", err)
+		} else {
+			reconciler.Keys = append(reconciler.Keys, vk)
+		}
+	}
+	if mouseDownFlag.IsSet && mouseUpFlag.IsSet {
+		if vk, err := mouseButtonCode(mouseDownFlag.Value, mouseData.Value); err != nil {
+			fmt.Println("Not reconciling the mouse binding:", err)
+		} else {
+			reconciler.Keys = append(reconciler.Keys, vk)
+		}
+	}
+	stop := make(chan struct{})
+	go input.RunReconciler(reconciler, time.Duration(reconcileFlag.Value)*time.Millisecond, stop)
+	return func() { close(stop) }
+}
+
 func exit() {
 	systrayActive = false
 	fmt.Println("Received shutdown signal")
-	close(mainfunc)
+	quitOnce.Do(func() { close(quit) })
 	fmt.Println("Requesting quit")
 	systray.Quit()
 	fmt.Println("Finished quitting")
@@ -179,7 +551,7 @@ func exit() {
 
 func onReady() {
 	systrayActive = true
-	systray.SetTemplateIcon(icons.MicMute, icons.MicMute)
+	SetTrayIcon(trayMuted)
 	systray.SetTitle("Muteiny")
 	systray.SetTooltip("Muteiny")
 
@@ -187,7 +559,25 @@ func onReady() {
 	if bindMode {
 		systray.AddMenuItem("Bind Mode", "Bind Mode Active")
 	} else {
-		inputDeviceMenu = systray.AddMenuItem(_lastDeviceName, "Input Device")
+		inputDeviceMenu = systray.AddMenuItem(engine.DeviceName(), "Input Device")
+		profileName, _ := ActiveProfile()
+		profileMenu = systray.AddMenuItem("Profile: "+profileName, "Active Profile")
+	}
+	if voiceFlag {
+		systray.AddMenuItem(fmt.Sprintf("Voice: %v/%v %vms (keys %s)", voiceAttackFlag.Value, voiceReleaseFlag.Value, voiceReleaseTimeFlag.Value, voicePTTFlag.Value), "Voice Activation Attack/Release Thresholds")
+	}
+	if mutedWarningFlag {
+		systray.AddMenuItem(fmt.Sprintf("Muted Warning: %v for %vms", mutedWarningThresholdFlag.Value, mutedWarningSustainFlag.Value), "Warns when you talk while muted")
+	}
+	if cuesFlag {
+		systray.AddMenuItem("Cues: On", "Sounds when the mic opens and closes")
+	}
+	if ducker != nil {
+		if len(ducker.Apps) > 0 {
+			systray.AddMenuItem(fmt.Sprintf("Ducking: %v%% %s", duckFlag.Value, strings.Join(ducker.Apps, ", ")), "Output lowered while the mic is open")
+		} else {
+			systray.AddMenuItem(fmt.Sprintf("Ducking: %v%%", duckFlag.Value), "Output lowered while the mic is open")
+		}
 	}
 	if mouseDownFlag.IsSet && mouseUpFlag.IsSet {
 		systray.AddMenuItem("MouseDown: "+fmt.Sprint(mouseDownFlag.Value), "Hooked Mouse Button Down")
@@ -199,8 +589,60 @@ func onReady() {
 	if keyboardFlag.IsSet {
 		systray.AddMenuItem("Hooked Key: '"+keyboardFlag.Value+"'", "Hooked Keyboard Button")
 	}
+	if deafenKeyFlag.IsSet {
+		systray.AddMenuItem("Deafen Key: '"+deafenKeyFlag.Value+"' ("+deafenModeFlag.Value+")", "Hooked Deafen Button")
+	}
+	addHookHealthMenus()
+	if mqttBridge != nil {
+		mqttMenu = systray.AddMenuItem("MQTT: "+mqttBridge.Status(), "Connection to the MQTT broker")
+	}
+	if obsBridge != nil {
+		obsMenu = systray.AddMenuItem("OBS: "+obsBridge.Status(), "Connection to obs-websocket")
+	}
+	if maxOpenFlag.Value > 0 {
+		watchdogMenu = systray.AddMenuItem(fmt.Sprintf("Max Open: %vs", maxOpenFlag.Value), "The mic is closed when it stays open longer than this")
+	}
+	if panicKeyFlag.IsSet {
+		systray.AddMenuItem("Panic Key: '"+panicKeyFlag.Value+"'", "Hooked Panic Button")
+	}
 	if holdFlag.IsSet {
-		systray.AddMenuItem("Hold Time: "+fmt.Sprint(holdFlag.Value)+"ms", "Mic Hold Time")
+		if silenceReleaseFlag {
+			systray.AddMenuItem(fmt.Sprintf("Release: silent %vms below %v, max %vms", silenceTimeFlag.Value, silenceThresholdFlag.Value, holdFlag.Value), "Silence Aware Release")
+		} else {
+			systray.AddMenuItem("Hold Time: "+fmt.Sprint(holdFlag.Value)+"ms", "Mic Hold Time")
+		}
+	}
+	if !bindMode {
+		systray.AddMenuItem("Startup: "+StartupPolicy()+" Shutdown: "+ShutdownPolicy(), "Startup and shutdown mute policies")
+		systray.AddMenuItem("Mute Mode: "+muteModeFlag.Value, "How the mic is silenced")
+		if preset := GainPresetFor(engine.DeviceName()); preset != nil {
+			systray.AddMenuItem("Gain: "+preset.Level, "Capture level set when the mic opens")
+		}
+		if muteModeFlag.Value == "fade" {
+			systray.AddMenuItem("Fade Time: "+fmt.Sprint(fadeFlag.Value)+"ms", "Time a fade takes")
+		}
+		systray.AddMenuItem("External Mute: "+externalMuteFlag.Value, "What happens when another program changes the mute state")
+	}
+
+	if !bindMode {
+		rearmMenu = systray.AddMenuItem("Re-arm Push-to-talk", "Enable push-to-talk again after a panic")
+		rearmMenu.Hide()
+		go func() {
+			runtime.LockOSThread()
+			InitOLE()
+			for range rearmMenu.ClickedCh {
+				engine.Rearm()
+			}
+		}()
+	}
+
+	if len(previousSession) > 0 {
+		mRestore := systray.AddMenuItem("Restore Previous Session", "Restore the original mute states left behind by a session that didn't shut down cleanly")
+		go func() {
+			<-mRestore.ClickedCh
+			RestorePreviousSession()
+			mRestore.Hide()
+		}()
 	}
 
 	// Ctrl+C to quit
@@ -229,50 +671,84 @@ func GetMute(aev *wca.IAudioEndpointVolume) bool /*, error*/ {
 }
 
 func SetMute(aev *wca.IAudioEndpointVolume, mute bool) error {
-	if err := aev.SetMute(mute, nil); err != nil {
+	if err := aev.SetMute(mute, muteinyEventContext); err != nil {
 		return err
 	}
 	return nil
 }
 
-func SetMuteThread(aev *wca.IAudioEndpointVolume, mute bool) error {
-	currentMute := GetMute(aev)
+func GetVolume(aev *wca.IAudioEndpointVolume) float32 {
+	var level float32
+	if err := aev.GetMasterVolumeLevelScalar(&level); err != nil {
+		fmt.Println("Error getting volume level, returning", err)
+		return 0
+	}
+	return level
+}
+
+func SetVolume(aev *wca.IAudioEndpointVolume, level float32) error {
+	if err := aev.SetMasterVolumeLevelScalar(level, muteinyEventContext); err != nil {
+		return err
+	}
+	return nil
+}
+
+func SetMuteThread(deviceName string, aev *wca.IAudioEndpointVolume, mute bool) error {
+	currentMute := muteStrategy.IsMuted(deviceName, aev)
 	if currentMute != mute {
 		do(func() {
 			runtime.LockOSThread()
-			SetMute(aev, mute)
-			runtime.UnlockOSThread()
-		})
-		if systrayActive {
+			//? Set the level while the mic is still muted so the first syllable is already at the preset
 			if !mute {
-				systray.SetTemplateIcon(icons.Mic, icons.Mic)
-			} else {
-				systray.SetTemplateIcon(icons.MicMute, icons.MicMute)
+				ApplyGain(deviceName, aev)
 			}
-		}
+			if err := muteStrategy.SetMuted(deviceName, aev, mute); err != nil {
+				fmt.Println("Error setting mute state", err)
+			}
+			if mute {
+				ResetGain(deviceName, aev)
+			}
+			runtime.UnlockOSThread()
+		})
+		SetTrayIcon(mute)
 		fmt.Printf("Mute State set to:%v\n", mute)
 	}
 	return nil
 }
 
-func runMouse(mouseDown int, mouseUp int) error {
+// Last mute state for the tray, kept while the tray is not running yet
+var trayMuted = true
+
+// SetTrayIcon shows the mute state in the tray
+func SetTrayIcon(mute bool) {
+	trayMuted = mute
+	if systrayActive {
+		if trayPanicked {
+			systray.SetTemplateIcon(icons.MicMuteBlack, icons.MicMuteBlack)
+		} else if !mute {
+			systray.SetTemplateIcon(icons.Mic, icons.Mic)
+		} else {
+			systray.SetTemplateIcon(icons.MicMute, icons.MicMute)
+		}
+	}
+}
 
-	mouseChan := make(chan types.MouseEvent, 1)
+func runMouse(mouseDown int, mouseUp int, stop <-chan struct{}, alive func()) error {
 
-	if err := mouse.Install(nil, mouseChan); err != nil {
+	mouseChan := make(chan types.MouseEvent, hookBuffer)
+
+	uninstall, err := installMouseHook(mouseChan, alive)
+	if err != nil {
 		return err
 	}
 
-	defer mouse.Uninstall()
-
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt)
+	defer uninstall()
 
 	fmt.Println("Start capturing mouse input")
 
 	for {
 		select {
-		case <-signalChan:
+		case <-stop:
 			fmt.Println("Shutting down mouse listener")
 			return nil
 		case m := <-mouseChan:
@@ -286,69 +762,75 @@ func runMouse(mouseDown int, mouseUp int) error {
 			// Check if the mouse event is the one we are looking for
 			keyNumber := int(m.Message)
 			if keyNumber == mouseDown {
-				// We run this every time to make sure we have the correct device
-				aev, release := GetDefaultDevice()
 				fmt.Printf("Down VK:%v Data:%v\n", int(m.Message), int(m.MouseData))
-				SetMuteThread(aev, false)
-				release()
+				engine.Press()
 			} else if keyNumber == mouseUp {
-				// We run this every time to make sure we have the correct device
-				aev, release := GetDefaultDevice()
 				fmt.Printf("Up VK:%v Data:%v\n", int(m.Message), int(m.MouseData))
-				// ? We run this goroutine because otherwise we lock the main thread causing lag due to the time.sleep
-				go func() {
-					time.Sleep(time.Duration(holdFlag.Value) * time.Millisecond)
-					SetMuteThread(aev, true)
-					release()
-				}()
+				engine.Release()
 			}
 			continue
 		}
 	}
 }
 
-func runKeyboard(keybind string) error {
-	keyboardChan := make(chan types.KeyboardEvent, 1)
+func runKeyboard(keybind string, deafenKeybind string, panicKeybind string, stop <-chan struct{}, alive func()) error {
+	keyboardChan := make(chan types.KeyboardEvent, hookBuffer)
 
-	if err := keyboard.Install(nil, keyboardChan); err != nil {
+	uninstall, err := installKeyboardHook(keyboardChan, alive)
+	if err != nil {
 		return err
 	}
 
-	defer keyboard.Uninstall()
-
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt)
+	defer uninstall()
 
 	fmt.Println("Start capturing keyboard input")
 
 	// We keep track of it so not to spam the down event
 	var lastWMState string = ""
+	var lastDeafenWMState string = ""
+	var lastPanicWMState string = ""
 
 	for {
 		select {
-		case <-signalChan:
+		case <-stop:
 			fmt.Println("Shutting down keyboard listener")
 			return nil
 		case k := <-keyboardChan:
 			// fmt.Printf("Received %v %v\n", k.Message, k.VKCode)
 			if fmt.Sprint(k.VKCode) == keybind {
-				if fmt.Sprint(k.Message) == "WM_KEYDOWN" && lastWMState != "down" {
+				//? After the watchdog closed the mic the next down counts as a new press, even if the up was lost
+				if fmt.Sprint(k.Message) == "WM_KEYDOWN" && (lastWMState != "down" || !engine.IsHeld()) {
 					lastWMState = "down"
-					// We run this every time to make sure we have the correct device
-					aev, release := GetDefaultDevice()
 					fmt.Printf("Down %v\n", k.VKCode)
-					SetMuteThread(aev, false)
-					release()
+					engine.Press()
 				} else if fmt.Sprint(k.Message) == "WM_KEYUP" && lastWMState != "up" {
-					// We run this every time to make sure we have the correct device
-					aev, release := GetDefaultDevice()
+					lastWMState = "up"
 					fmt.Printf("Up %v\n", k.VKCode)
-					go func() {
-						time.Sleep(time.Duration(holdFlag.Value) * time.Millisecond)
-						SetMute(aev, true)
-						release()
-						lastWMState = "up"
-					}()
+					engine.Release()
+				}
+			} else if deafenKeybind != "" && fmt.Sprint(k.VKCode) == deafenKeybind {
+				if fmt.Sprint(k.Message) == "WM_KEYDOWN" && lastDeafenWMState != "down" {
+					lastDeafenWMState = "down"
+					fmt.Printf("Deafen Down %v\n", k.VKCode)
+					if deafenModeFlag.Value == "toggle" {
+						engine.ToggleDeafen()
+					} else {
+						engine.SetDeafened(true)
+					}
+				} else if fmt.Sprint(k.Message) == "WM_KEYUP" && lastDeafenWMState != "up" {
+					lastDeafenWMState = "up"
+					fmt.Printf("Deafen Up %v\n", k.VKCode)
+					if deafenModeFlag.Value == "hold" {
+						engine.SetDeafened(false)
+					}
+				}
+			} else if panicKeybind != "" && fmt.Sprint(k.VKCode) == panicKeybind {
+				if fmt.Sprint(k.Message) == "WM_KEYDOWN" && lastPanicWMState != "down" {
+					lastPanicWMState = "down"
+					fmt.Printf("Panic Down %v\n", k.VKCode)
+					engine.TogglePanic()
+				} else if fmt.Sprint(k.Message) == "WM_KEYUP" {
+					lastPanicWMState = "up"
 				}
 			}
 			continue
@@ -454,25 +936,3 @@ func findBindMode() {
 // 	}
 // 	return nil
 // }
-
-// Old Volume code kept for reference
-// func SetVolumeLevel(aev *wca.IAudioEndpointVolume, volumeLevel float32) error {
-// 	var currentVolumeLevel float32
-// 	if err := aev.GetMasterVolumeLevel(&currentVolumeLevel); err != nil {
-// 		return err
-// 	}
-// 	if currentVolumeLevel != volumeLevel {
-// 		if err := aev.SetMasterVolumeLevel(volumeLevel, nil); err != nil {
-// 			return err
-// 		}
-// 		if systrayActive {
-// 			if volumeLevel != 0 {
-// 				systray.SetTemplateIcon(icons.Mic, icons.Mic)
-// 			} else {
-// 				systray.SetTemplateIcon(icons.MicMute, icons.MicMute)
-// 			}
-// 		}
-// 		fmt.Printf("Volume Level set to:%v\n", volumeLevel)
-// 	}
-// 	return nil
-// }
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+// MuteStrategy is how Muteiny silences a capture device, selected with -mutemode
+type MuteStrategy interface {
+	// IsMuted reports whether the device is silenced by this strategy
+	IsMuted(deviceName string, aev *wca.IAudioEndpointVolume) bool
+	// SetMuted silences or opens the device
+	SetMuted(deviceName string, aev *wca.IAudioEndpointVolume, mute bool) error
+	// NotifiedMuted tells if a control change notification means the device is silenced
+	NotifiedMuted(muted bool, volume float32) bool
+	// Restore undoes what the strategy did to the device, called on shutdown and when the default device changes
+	Restore(deviceName string, aev *wca.IAudioEndpointVolume) error
+}
+
+var muteStrategy MuteStrategy = &EndpointMute{}
+
+// NewMuteStrategy returns the strategy for a -mutemode value
+func NewMuteStrategy(mode string) MuteStrategy {
+	switch mode {
+	case "volume":
+		return NewVolumeMute()
+	case "fade":
+		return NewFadeMute(time.Duration(fadeFlag.Value) * time.Millisecond)
+	default:
+		return &EndpointMute{}
+	}
+}
+
+// EndpointMute toggles the mute flag of the endpoint
+type EndpointMute struct{}
+
+func (m *EndpointMute) IsMuted(deviceName string, aev *wca.IAudioEndpointVolume) bool {
+	return GetMute(aev)
+}
+
+func (m *EndpointMute) SetMuted(deviceName string, aev *wca.IAudioEndpointVolume, mute bool) error {
+	return SetMute(aev, mute)
+}
+
+func (m *EndpointMute) NotifiedMuted(muted bool, volume float32) bool {
+	return muted
+}
+
+func (m *EndpointMute) Restore(deviceName string, aev *wca.IAudioEndpointVolume) error {
+	//? The mute flag is restored from deviceStatesMap by the caller
+	return nil
+}
+
+// VolumeMute silences the device by setting the capture level to 0, for apps that ignore or complain about the mute flag.
+// The level from before it was silenced is kept in the state file so it can be restored after a crash.
+type VolumeMute struct{}
+
+func NewVolumeMute() *VolumeMute {
+	return &VolumeMute{}
+}
+
+func (m *VolumeMute) IsMuted(deviceName string, aev *wca.IAudioEndpointVolume) bool {
+	return GetVolume(aev) == 0
+}
+
+func (m *VolumeMute) SetMuted(deviceName string, aev *wca.IAudioEndpointVolume, mute bool) error {
+	if mute {
+		//? Remember the current level, the user may have changed it while the mic was open
+		m.saveLevel(deviceName, GetVolume(aev))
+		return SetVolume(aev, 0)
+	}
+	level, ok := m.openLevel(deviceName, aev)
+	if !ok {
+		return nil
+	}
+	return SetVolume(aev, level)
+}
+
+// saveLevel remembers the level of the device before it gets silenced
+func (m *VolumeMute) saveLevel(deviceName string, level float32) {
+	if level <= 0 {
+		return
+	}
+	UpdateState(func(state *SavedState) {
+		state.Volumes[deviceName] = level
+	})
+}
+
+// openLevel returns the level to set when the mic opens, false if the device should be left alone
+func (m *VolumeMute) openLevel(deviceName string, aev *wca.IAudioEndpointVolume) (float32, bool) {
+	var level float32
+	var ok bool
+	ReadState(func(state *SavedState) {
+		level, ok = state.Volumes[deviceName]
+	})
+	if !ok {
+		//? We never silenced this device, so there is nothing to restore
+		if GetVolume(aev) > 0 {
+			return 0, false
+		}
+		level = 1
+	}
+	return level, true
+}
+
+func (m *VolumeMute) NotifiedMuted(muted bool, volume float32) bool {
+	return volume == 0
+}
+
+func (m *VolumeMute) Restore(deviceName string, aev *wca.IAudioEndpointVolume) error {
+	var level float32
+	var ok bool
+	ReadState(func(state *SavedState) {
+		level, ok = state.Volumes[deviceName]
+	})
+	if !ok {
+		return nil
+	}
+	fmt.Println("Restoring volume level for:", deviceName, "to:", level)
+	if err := SetVolume(aev, level); err != nil {
+		return err
+	}
+	UpdateState(func(state *SavedState) {
+		delete(state.Volumes, deviceName)
+	})
+	return nil
+}
+
+// How often the level is updated during a fade
+const fadeStep = 10 * time.Millisecond
+
+// FadeMute works like VolumeMute but ramps the capture level over a duration instead of switching it,
+// which avoids the click of a hard mute and the chopped first syllable on some interfaces.
+type FadeMute struct {
+	*VolumeMute
+	duration time.Duration
+
+	fadeMu sync.Mutex
+	muted  bool          // Where the running or last fade is heading
+	cancel chan struct{} // Closed to stop the running fade
+	done   chan struct{} // Closed when the running fade has stopped
+}
+
+func NewFadeMute(duration time.Duration) *FadeMute {
+	return &FadeMute{VolumeMute: NewVolumeMute(), duration: duration}
+}
+
+func (m *FadeMute) IsMuted(deviceName string, aev *wca.IAudioEndpointVolume) bool {
+	m.fadeMu.Lock()
+	defer m.fadeMu.Unlock()
+	//? Mid fade the level says nothing useful, what matters is where it is heading
+	if m.isFading() {
+		return m.muted
+	}
+	return m.VolumeMute.IsMuted(deviceName, aev)
+}
+
+func (m *FadeMute) SetMuted(deviceName string, aev *wca.IAudioEndpointVolume, mute bool) error {
+	m.fadeMu.Lock()
+	defer m.fadeMu.Unlock()
+
+	//? A re-press mid fade reverses it from wherever the level is now
+	wasFading := m.stopFade()
+	from := GetVolume(aev)
+	var to float32
+	if mute {
+		//? Mid fade the current level is not the one the user set
+		if !wasFading {
+			m.saveLevel(deviceName, from)
+		}
+	} else {
+		level, ok := m.openLevel(deviceName, aev)
+		if !ok {
+			return nil
+		}
+		to = level
+	}
+
+	m.muted = mute
+	cancel := make(chan struct{})
+	done := make(chan struct{})
+	m.cancel, m.done = cancel, done
+
+	//? The caller releases the device when we return, keep it alive until the fade is done
+	aev.AddRef()
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		InitOLE()
+		defer ole.CoUninitialize()
+		defer aev.Release()
+
+		steps := int(m.duration / fadeStep)
+		if steps < 1 {
+			steps = 1
+		}
+		ticker := time.NewTicker(fadeStep)
+		defer ticker.Stop()
+		for i := 1; i <= steps; i++ {
+			select {
+			case <-cancel:
+				return
+			case <-ticker.C:
+			}
+			if err := SetVolume(aev, from+(to-from)*float32(i)/float32(steps)); err != nil {
+				fmt.Println("Error setting volume level", err)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (m *FadeMute) Restore(deviceName string, aev *wca.IAudioEndpointVolume) error {
+	m.fadeMu.Lock()
+	m.stopFade()
+	m.fadeMu.Unlock()
+	return m.VolumeMute.Restore(deviceName, aev)
+}
+
+// isFading is true while a fade is running, fadeMu must be held
+func (m *FadeMute) isFading() bool {
+	if m.done == nil {
+		return false
+	}
+	select {
+	case <-m.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// stopFade stops the running fade and waits for it, returns true if one was running. fadeMu must be held
+func (m *FadeMute) stopFade() bool {
+	fading := m.isFading()
+	if m.cancel != nil {
+		close(m.cancel)
+		<-m.done
+		m.cancel, m.done = nil, nil
+	}
+	return fading
+}
+
+// RestoreSavedVolumes puts back levels left in the state file by a session that didn't shut down cleanly
+func RestoreSavedVolumes() {
+	var volumes map[string]float32
+	ReadState(func(state *SavedState) {
+		volumes = make(map[string]float32, len(state.Volumes))
+		for deviceName, level := range state.Volumes {
+			volumes[deviceName] = level
+		}
+	})
+	if len(volumes) == 0 {
+		return
+	}
+	fmt.Println("Found volume levels from a previous session, restoring them")
+	devices, releaseAll := GetAllDevices()
+	defer releaseAll()
+	for deviceName, level := range volumes {
+		if device := devices[deviceName]; device != nil {
+			fmt.Println("Restoring volume level for:", deviceName, "to:", level)
+			if err := SetVolume(device, level); err != nil {
+				fmt.Println("Error setting volume level for:", deviceName, err)
+				continue
+			}
+			UpdateState(func(state *SavedState) {
+				delete(state.Volumes, deviceName)
+			})
+		} else {
+			//? Keep it for the next launch, the device may just be unplugged
+			fmt.Println("Device not found:", deviceName)
+		}
+	}
+}
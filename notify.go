@@ -0,0 +1,120 @@
+package main
+
+import (
+	"Muteiny/icons"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/getlantern/systray"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	shell32              = windows.NewLazySystemDLL("shell32.dll")
+	procShellNotifyIcon  = shell32.NewProc("Shell_NotifyIconW")
+	procFindWindowEx     = user32.NewProc("FindWindowExW")
+	procMessageBeep      = user32.NewProc("MessageBeep")
+	procGetWindowProcess = user32.NewProc("GetWindowThreadProcessId")
+)
+
+// Window class and icon id used by github.com/getlantern/systray, it has no API for balloons so we talk to its icon directly
+const (
+	systrayClassName = "SystrayClass"
+	systrayIconID    = 100
+)
+
+// NOTIFYICONDATAW, uTimeout and uVersion share a union so they are a single field
+type notifyIconData struct {
+	Size                       uint32
+	Wnd                        windows.Handle
+	ID, Flags, CallbackMessage uint32
+	Icon                       windows.Handle
+	Tip                        [128]uint16
+	State, StateMask           uint32
+	Info                       [256]uint16
+	TimeoutOrVersion           uint32
+	InfoTitle                  [64]uint16
+	InfoFlags                  uint32
+	GuidItem                   windows.GUID
+	BalloonIcon                windows.Handle
+}
+
+// findSystrayWindow finds the hidden window of our tray icon, other programs may use the same class so the process has to match
+func findSystrayWindow() (windows.Handle, error) {
+	className, err := windows.UTF16PtrFromString(systrayClassName)
+	if err != nil {
+		return 0, err
+	}
+	pid := windows.GetCurrentProcessId()
+	var hWnd uintptr
+	for {
+		hWnd, _, _ = procFindWindowEx.Call(0, hWnd, uintptr(unsafe.Pointer(className)), 0)
+		if hWnd == 0 {
+			return 0, errors.New("tray window not found")
+		}
+		var windowPid uint32
+		procGetWindowProcess.Call(hWnd, uintptr(unsafe.Pointer(&windowPid)))
+		if windowPid == pid {
+			return windows.Handle(hWnd), nil
+		}
+	}
+}
+
+// ShowBalloon shows a warning notification from the tray icon
+func ShowBalloon(title, message string) error {
+	const (
+		NIM_MODIFY   = 0x00000001
+		NIF_INFO     = 0x00000010
+		NIIF_WARNING = 0x00000002
+	)
+	if !systrayActive {
+		return errors.New("tray is not running")
+	}
+	hWnd, err := findSystrayWindow()
+	if err != nil {
+		return err
+	}
+	nid := &notifyIconData{
+		Wnd:       hWnd,
+		ID:        systrayIconID,
+		Flags:     NIF_INFO,
+		InfoFlags: NIIF_WARNING,
+	}
+	nid.Size = uint32(unsafe.Sizeof(*nid))
+	copy(nid.InfoTitle[:len(nid.InfoTitle)-1], syscall.StringToUTF16(title))
+	copy(nid.Info[:len(nid.Info)-1], syscall.StringToUTF16(message))
+	if res, _, err := procShellNotifyIcon.Call(NIM_MODIFY, uintptr(unsafe.Pointer(nid))); res == 0 {
+		return err
+	}
+	return nil
+}
+
+// FlashTrayIcon blinks the tray icon and leaves it showing the current mute state
+func FlashTrayIcon(times int) {
+	if !systrayActive {
+		return
+	}
+	for i := 0; i < times; i++ {
+		systray.SetTemplateIcon(icons.Mic, icons.Mic)
+		time.Sleep(150 * time.Millisecond)
+		systray.SetTemplateIcon(icons.MicMute, icons.MicMute)
+		time.Sleep(150 * time.Millisecond)
+	}
+	SetTrayIcon(trayMuted)
+}
+
+// WarnTalkingWhileMuted tells the user they are talking into a muted mic
+func WarnTalkingWhileMuted() {
+	const MB_ICONEXCLAMATION = 0x00000030
+	fmt.Println("Warning: you are talking while muted, press your push-to-talk key")
+	if err := ShowBalloon("Muteiny", "You are talking while muted! Press your push-to-talk key."); err != nil {
+		fmt.Println("Error showing notification", err)
+	}
+	if mutedWarningSoundFlag {
+		procMessageBeep.Call(MB_ICONEXCLAMATION)
+	}
+	go FlashTrayIcon(3)
+}
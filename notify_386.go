@@ -0,0 +1,12 @@
+package main
+
+import "unsafe"
+
+// Size of NOTIFYICONDATAW on x86, Shell_NotifyIconW reads the fields at the wrong offsets if our struct is off
+const notifyIconDataSize = 956
+
+// Fails to compile when notifyIconData is not exactly notifyIconDataSize bytes
+var (
+	_ [notifyIconDataSize - unsafe.Sizeof(notifyIconData{})]struct{}
+	_ [unsafe.Sizeof(notifyIconData{}) - notifyIconDataSize]struct{}
+)
@@ -0,0 +1,266 @@
+// Package obs is a client for the obs-websocket 5 protocol
+package obs
+
+import (
+	"Muteiny/websocket"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Message opcodes
+const (
+	OpHello           = 0
+	OpIdentify        = 1
+	OpIdentified      = 2
+	OpEvent           = 5
+	OpRequest         = 6
+	OpRequestResponse = 7
+)
+
+// Event subscriptions, see EventSubscription in the protocol
+const (
+	SubscribeGeneral    = 1 << 0
+	SubscribeScenes     = 1 << 2
+	SubscribeInputs     = 1 << 3
+	SubscribeSceneItems = 1 << 7
+)
+
+// The rpc version this client speaks
+const rpcVersion = 1
+
+// How long a request waits for its response
+const requestTimeout = 5 * time.Second
+
+// Message is the envelope of every obs-websocket message
+type Message struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+// Hello is sent by the server when the connection opens
+type Hello struct {
+	ObsWebSocketVersion string `json:"obsWebSocketVersion"`
+	RPCVersion          int    `json:"rpcVersion"`
+	Authentication      *struct {
+		Challenge string `json:"challenge"`
+		Salt      string `json:"salt"`
+	} `json:"authentication,omitempty"`
+}
+
+// Identify answers Hello
+type Identify struct {
+	RPCVersion         int    `json:"rpcVersion"`
+	Authentication     string `json:"authentication,omitempty"`
+	EventSubscriptions int    `json:"eventSubscriptions"`
+}
+
+// Event is an event sent by OBS
+type Event struct {
+	EventType   string          `json:"eventType"`
+	EventIntent int             `json:"eventIntent"`
+	EventData   json.RawMessage `json:"eventData,omitempty"`
+}
+
+// Request is a request to OBS
+type Request struct {
+	RequestType string      `json:"requestType"`
+	RequestID   string      `json:"requestId"`
+	RequestData interface{} `json:"requestData,omitempty"`
+}
+
+// RequestResponse is the answer to a Request
+type RequestResponse struct {
+	RequestType   string `json:"requestType"`
+	RequestID     string `json:"requestId"`
+	RequestStatus struct {
+		Result  bool   `json:"result"`
+		Code    int    `json:"code"`
+		Comment string `json:"comment,omitempty"`
+	} `json:"requestStatus"`
+	ResponseData json.RawMessage `json:"responseData,omitempty"`
+}
+
+// Authenticate computes the Identify authentication string from the password and the Hello challenge
+func Authenticate(password, salt, challenge string) string {
+	secret := sha256.Sum256([]byte(password + salt))
+	secretString := base64.StdEncoding.EncodeToString(secret[:])
+	auth := sha256.Sum256([]byte(secretString + challenge))
+	return base64.StdEncoding.EncodeToString(auth[:])
+}
+
+// Client is an identified connection to OBS
+type Client struct {
+	conn    *websocket.Conn
+	onEvent func(Event)
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]chan RequestResponse
+	err     error
+	done    chan struct{}
+}
+
+// Connect opens the connection, identifies with the password and starts delivering the subscribed events to onEvent.
+// onEvent runs on the goroutine that reads the connection, it must not wait for a Request.
+func Connect(url, password string, subscriptions int, onEvent func(Event)) (*Client, error) {
+	conn, err := websocket.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	var hello Hello
+	if err := readMessage(conn, OpHello, &hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	identify := Identify{RPCVersion: rpcVersion, EventSubscriptions: subscriptions}
+	if hello.Authentication != nil {
+		if password == "" {
+			conn.Close()
+			return nil, errors.New("obs: the server requires a password")
+		}
+		identify.Authentication = Authenticate(password, hello.Authentication.Salt, hello.Authentication.Challenge)
+	}
+	if err := writeMessage(conn, OpIdentify, identify); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	//? A wrong password closes the connection instead of answering
+	if err := readMessage(conn, OpIdentified, nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("obs: identify failed, check the password: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	c := &Client{conn: conn, onEvent: onEvent, pending: make(map[string]chan RequestResponse), done: make(chan struct{})}
+	go c.readLoop()
+	return c, nil
+}
+
+func readMessage(conn *websocket.Conn, op int, v interface{}) error {
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	var message Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		return err
+	}
+	if message.Op != op {
+		return fmt.Errorf("obs: expected op %d, got %d", op, message.Op)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(message.D, v)
+}
+
+func writeMessage(conn *websocket.Conn, op int, v interface{}) error {
+	d, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(Message{Op: op, D: d})
+	if err != nil {
+		return err
+	}
+	return conn.WriteText(data)
+}
+
+func (c *Client) readLoop() {
+	var err error
+	defer func() {
+		c.mu.Lock()
+		c.err = err
+		for id, response := range c.pending {
+			close(response)
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		close(c.done)
+	}()
+	for {
+		var data []byte
+		_, data, err = c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var message Message
+		if json.Unmarshal(data, &message) != nil {
+			continue
+		}
+		switch message.Op {
+		case OpEvent:
+			var event Event
+			if json.Unmarshal(message.D, &event) == nil && c.onEvent != nil {
+				c.onEvent(event)
+			}
+		case OpRequestResponse:
+			var response RequestResponse
+			if json.Unmarshal(message.D, &response) != nil {
+				continue
+			}
+			c.mu.Lock()
+			waiting := c.pending[response.RequestID]
+			delete(c.pending, response.RequestID)
+			c.mu.Unlock()
+			if waiting != nil {
+				waiting <- response
+			}
+		}
+	}
+}
+
+// Request sends a request and decodes the response data into result, which may be nil
+func (c *Client) Request(requestType string, data interface{}, result interface{}) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := fmt.Sprint(c.nextID)
+	waiting := make(chan RequestResponse, 1)
+	c.pending[id] = waiting
+	c.mu.Unlock()
+
+	if err := writeMessage(c.conn, OpRequest, Request{RequestType: requestType, RequestID: id, RequestData: data}); err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return err
+	}
+	select {
+	case response, ok := <-waiting:
+		if !ok {
+			return errors.New("obs: connection lost")
+		}
+		if !response.RequestStatus.Result {
+			return fmt.Errorf("obs: %s failed with code %d: %s", requestType, response.RequestStatus.Code, response.RequestStatus.Comment)
+		}
+		if result == nil || response.ResponseData == nil {
+			return nil
+		}
+		return json.Unmarshal(response.ResponseData, result)
+	case <-time.After(requestTimeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("obs: %s timed out", requestType)
+	}
+}
+
+// Done is closed when the connection is lost
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,381 @@
+package obs
+
+import (
+	"Muteiny/websocket"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeOBS is a stand-in obs-websocket server, it answers requests with handle and sends its connections the events
+type fakeOBS struct {
+	Password string
+	handle   func(request Request) RequestResponse
+
+	server   *httptest.Server
+	connects int32
+
+	mu       sync.Mutex
+	identify []Identify
+	conns    []*websocket.Conn
+}
+
+const (
+	testSalt      = "lM1GncleQOaCu9lT1yeUZhFYnqhsLLP1G5lAGo3ixaI="
+	testChallenge = "+IxH4CnCiqpX1rM9scsNynZzbOe4KhDeYcTNS3PDaeY="
+)
+
+func newFakeOBS(t *testing.T, password string) *fakeOBS {
+	f := &fakeOBS{Password: password}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(func() {
+		f.DropAll()
+		f.server.Close()
+	})
+	return f
+}
+
+func (f *fakeOBS) URL() string {
+	return "ws" + strings.TrimPrefix(f.server.URL, "http")
+}
+
+func (f *fakeOBS) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	atomic.AddInt32(&f.connects, 1)
+	defer conn.Close()
+	hello := Hello{ObsWebSocketVersion: "5.0.0", RPCVersion: rpcVersion}
+	if f.Password != "" {
+		hello.Authentication = &struct {
+			Challenge string `json:"challenge"`
+			Salt      string `json:"salt"`
+		}{testChallenge, testSalt}
+	}
+	if writeMessage(conn, OpHello, hello) != nil {
+		return
+	}
+	var identify Identify
+	if readMessage(conn, OpIdentify, &identify) != nil {
+		return
+	}
+	f.mu.Lock()
+	f.identify = append(f.identify, identify)
+	f.mu.Unlock()
+	if f.Password != "" && identify.Authentication != Authenticate(f.Password, testSalt, testChallenge) {
+		//? OBS closes with 4009 AuthenticationFailed, a plain close is enough for the client
+		return
+	}
+	if writeMessage(conn, OpIdentified, map[string]int{"negotiatedRpcVersion": rpcVersion}) != nil {
+		return
+	}
+	f.mu.Lock()
+	f.conns = append(f.conns, conn)
+	f.mu.Unlock()
+	for {
+		var request Request
+		if readMessage(conn, OpRequest, &request) != nil {
+			return
+		}
+		if f.handle == nil {
+			continue
+		}
+		response := f.handle(request)
+		response.RequestType, response.RequestID = request.RequestType, request.RequestID
+		if writeMessage(conn, OpRequestResponse, response) != nil {
+			return
+		}
+	}
+}
+
+// Send sends an event to every identified connection
+func (f *fakeOBS) Send(event Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		writeMessage(conn, OpEvent, event)
+	}
+}
+
+// DropAll closes every identified connection, like OBS quitting
+func (f *fakeOBS) DropAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func ok(data interface{}) RequestResponse {
+	var response RequestResponse
+	response.RequestStatus.Result = true
+	response.RequestStatus.Code = 100
+	if data != nil {
+		response.ResponseData, _ = json.Marshal(data)
+	}
+	return response
+}
+
+func TestAuthenticate(t *testing.T) {
+	//? The example of the obs-websocket protocol documentation
+	got := Authenticate("supersecretpassword", testSalt, testChallenge)
+	if want := "1Ct943GAT+6YQUUX47Ia/ncufilbe6+oD6lY+5kaCu4="; got != want {
+		t.Fatalf("Authenticate = %s, want %s", got, want)
+	}
+}
+
+func TestConnectIdentifies(t *testing.T) {
+	tests := []struct {
+		name           string
+		serverPassword string
+		password       string
+		fail           bool
+	}{
+		{"no auth", "", "", false},
+		{"no auth ignores a password", "", "unused", false},
+		{"auth", "supersecretpassword", "supersecretpassword", false},
+		{"wrong password", "supersecretpassword", "guess", true},
+		{"missing password", "supersecretpassword", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeOBS(t, test.serverPassword)
+			client, err := Connect(server.URL(), test.password, SubscribeInputs, nil)
+			if test.fail {
+				if err == nil {
+					client.Close()
+					t.Fatal("connected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if len(server.identify) != 1 {
+				t.Fatalf("server got %d Identify", len(server.identify))
+			}
+			identify := server.identify[0]
+			if identify.RPCVersion != rpcVersion || identify.EventSubscriptions != SubscribeInputs {
+				t.Fatalf("Identify = %+v", identify)
+			}
+			if test.serverPassword == "" && identify.Authentication != "" {
+				t.Fatal("authentication sent without a challenge")
+			}
+		})
+	}
+}
+
+func TestRequest(t *testing.T) {
+	server := newFakeOBS(t, "")
+	server.handle = func(request Request) RequestResponse {
+		switch request.RequestType {
+		case "GetSceneItemId":
+			data := request.RequestData.(map[string]interface{})
+			if data["sceneName"] != "Main" || data["sourceName"] != "Mic Icon" {
+				break
+			}
+			return ok(map[string]int{"sceneItemId": 7})
+		case "SetInputMute":
+			return ok(nil)
+		}
+		var response RequestResponse
+		response.RequestStatus.Code = 600
+		response.RequestStatus.Comment = "No source was found"
+		return response
+	}
+	client, err := Connect(server.URL(), "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var item struct {
+		SceneItemID int `json:"sceneItemId"`
+	}
+	if err := client.Request("GetSceneItemId", map[string]string{"sceneName": "Main", "sourceName": "Mic Icon"}, &item); err != nil {
+		t.Fatal(err)
+	}
+	if item.SceneItemID != 7 {
+		t.Fatalf("sceneItemId = %d, want 7", item.SceneItemID)
+	}
+	if err := client.Request("SetInputMute", map[string]interface{}{"inputName": "Mic", "inputMuted": true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	err = client.Request("GetSceneItemId", map[string]string{"sceneName": "Main", "sourceName": "Missing"}, &item)
+	if err == nil || !strings.Contains(err.Error(), "600") || !strings.Contains(err.Error(), "No source was found") {
+		t.Fatalf("failed request error = %v", err)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	server := newFakeOBS(t, "")
+	server.handle = func(request Request) RequestResponse {
+		return ok(request.RequestData)
+	}
+	client, err := Connect(server.URL(), "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var result struct {
+				N int `json:"n"`
+			}
+			if err := client.Request("Echo", map[string]int{"n": i}, &result); err != nil {
+				t.Error(err)
+			} else if result.N != i {
+				t.Errorf("request %d got the response of %d", i, result.N)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestEventsAndConnectionLoss(t *testing.T) {
+	server := newFakeOBS(t, "")
+	events := make(chan Event, 1)
+	client, err := Connect(server.URL(), "", SubscribeInputs, func(event Event) { events <- event })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server.Send(Event{EventType: "InputMuteStateChanged", EventIntent: SubscribeInputs, EventData: json.RawMessage(`{"inputName":"Mic","inputMuted":true}`)})
+	select {
+	case event := <-events:
+		if event.EventType != "InputMuteStateChanged" || string(event.EventData) != `{"inputName":"Mic","inputMuted":true}` {
+			t.Fatalf("event = %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+
+	server.DropAll()
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after the server went away")
+	}
+	if err := client.Request("GetVersion", nil, nil); err == nil {
+		t.Fatal("request on a lost connection succeeded")
+	}
+}
+
+func TestReconnector(t *testing.T) {
+	server := newFakeOBS(t, "pw")
+	server.handle = func(Request) RequestResponse { return ok(nil) }
+	var mu sync.Mutex
+	var statuses []string
+	sessions := make(chan *Client)
+	reconnector := &Reconnector{
+		URL:        server.URL(),
+		Password:   "pw",
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 4 * time.Millisecond,
+		OnStatus: func(status string) {
+			mu.Lock()
+			statuses = append(statuses, status)
+			mu.Unlock()
+		},
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		reconnector.Run(stop, func(client *Client, stop <-chan struct{}) {
+			sessions <- client
+			select {
+			case <-client.Done():
+			case <-stop:
+			}
+		})
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case client := <-sessions:
+			if err := client.Request("GetVersion", nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no session %d", i+1)
+		}
+		//? OBS quits, the reconnector has to come back on its own
+		if i < 2 {
+			server.DropAll()
+		}
+	}
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after stop")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"connected", "disconnected", "connected", "disconnected", "connected"}
+	if strings.Join(statuses, ",") != strings.Join(want, ",") {
+		t.Fatalf("statuses = %v, want %v", statuses, want)
+	}
+}
+
+func TestReconnectorBackoff(t *testing.T) {
+	//? Nothing listens here once the server is closed
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	server.Close()
+
+	var errors int32
+	var attempts []time.Time
+	var mu sync.Mutex
+	reconnector := &Reconnector{
+		URL:        url,
+		MinBackoff: 5 * time.Millisecond,
+		MaxBackoff: 20 * time.Millisecond,
+		OnStatus: func(status string) {
+			mu.Lock()
+			attempts = append(attempts, time.Now())
+			mu.Unlock()
+		},
+		OnError: func(err error) { atomic.AddInt32(&errors, 1) },
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		reconnector.Run(stop, func(*Client, <-chan struct{}) { t.Error("connected to nothing") })
+		close(done)
+	}()
+	time.Sleep(150 * time.Millisecond)
+	close(stop)
+	<-done
+
+	//? Only the first error of a series is reported
+	if n := atomic.LoadInt32(&errors); n != 1 {
+		t.Fatalf("OnError called %d times, want 1", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	//? 5 + 10 + 20 + 20... ms apart, far fewer attempts than without backoff
+	if len(attempts) < 4 || len(attempts) > 12 {
+		t.Fatalf("%d attempts in 150ms", len(attempts))
+	}
+	for i := 4; i < len(attempts); i++ {
+		if gap := attempts[i].Sub(attempts[i-1]); gap < 20*time.Millisecond {
+			t.Fatalf("attempt %d came %v after the last, the backoff should have reached its maximum", i, gap)
+		}
+	}
+}
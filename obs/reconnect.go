@@ -0,0 +1,58 @@
+package obs
+
+import "time"
+
+// Reconnector keeps a connection to OBS, reconnecting with backoff while OBS isn't running
+type Reconnector struct {
+	URL           string
+	Password      string
+	Subscriptions int
+	OnEvent       func(Event) // Gets the events of every connection, see Connect
+
+	MinBackoff time.Duration // Delay before reconnecting, doubled after every failed attempt in a row
+	MaxBackoff time.Duration
+
+	OnStatus func(status string) // Called with "connected" and "disconnected", may be nil
+	OnError  func(err error)     // Called with the first error of a series of failed attempts, may be nil
+}
+
+// Run connects until stop is closed, session runs with every identified connection and must return once it is done or stop is closed
+func (r *Reconnector) Run(stop <-chan struct{}, session func(client *Client, stop <-chan struct{})) {
+	backoff := r.MinBackoff
+	for {
+		client, err := Connect(r.URL, r.Password, r.Subscriptions, r.OnEvent)
+		if err == nil {
+			r.status("connected")
+			session(client, stop)
+			client.Close()
+			//? The connection worked, so OBS was just closed and may be back soon
+			backoff = r.MinBackoff
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		r.status("disconnected")
+		if err != nil && backoff == r.MinBackoff && r.OnError != nil {
+			r.OnError(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		if err != nil {
+			backoff *= 2
+			if backoff > r.MaxBackoff {
+				backoff = r.MaxBackoff
+			}
+		}
+	}
+}
+
+func (r *Reconnector) status(status string) {
+	if r.OnStatus != nil {
+		r.OnStatus(status)
+	}
+}
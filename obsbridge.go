@@ -0,0 +1,198 @@
+package main
+
+import (
+	"Muteiny/obs"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/getlantern/systray"
+)
+
+var obsBridge *OBSBridge
+var obsMenu *systray.MenuItem
+
+// Control input changes waiting to be applied, more are dropped
+const obsCommandQueue = 16
+
+// OBSBridge mirrors the mic state to OBS over obs-websocket, and lets an OBS input drive the mic
+type OBSBridge struct {
+	URL      string
+	Password string
+	Input    string // Input muted while the mic is closed
+	Scene    string // Scene of Item
+	Item     string // Scene item shown while the mic is open
+	Control  string // Input whose mute state, toggled by an OBS hotkey, opens and closes the mic
+
+	mu       sync.Mutex
+	open     bool
+	status   string
+	changed  chan struct{}
+	commands chan bool     // Mic states set from the control input
+	items    chan struct{} // Scene items of Scene were created or removed, the id of Item has to be looked up again
+}
+
+func NewOBSBridge(url, password string) *OBSBridge {
+	return &OBSBridge{URL: url, Password: password, status: "connecting", changed: make(chan struct{}, 1), commands: make(chan bool, obsCommandQueue), items: make(chan struct{}, 1)}
+}
+
+// StateChanged is called with the mic state, the latest state is sent once connected
+func (b *OBSBridge) StateChanged(open bool) {
+	b.mu.Lock()
+	b.open = open
+	b.mu.Unlock()
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Status is "connecting", "connected" or "disconnected"
+func (b *OBSBridge) Status() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+func (b *OBSBridge) setStatus(status string) {
+	b.mu.Lock()
+	b.status = status
+	b.mu.Unlock()
+	if obsMenu != nil {
+		obsMenu.SetTitle("OBS: " + status)
+	}
+}
+
+// Run keeps a connection to OBS until stop is closed, reconnecting with backoff while OBS isn't running
+func (b *OBSBridge) Run(stop <-chan struct{}) {
+	go b.runCommands(stop)
+	subscriptions := 0
+	if b.Control != "" {
+		subscriptions |= obs.SubscribeInputs
+	}
+	if b.Item != "" {
+		subscriptions |= obs.SubscribeSceneItems
+	}
+	reconnector := &obs.Reconnector{
+		URL:           b.URL,
+		Password:      b.Password,
+		Subscriptions: subscriptions,
+		OnEvent:       b.handleEvent,
+		MinBackoff:    minRestartBackoff,
+		MaxBackoff:    maxRestartBackoff,
+		OnStatus: func(status string) {
+			if status == "connected" {
+				fmt.Println("Connected to OBS at", b.URL)
+			}
+			b.setStatus(status)
+		},
+		OnError: func(err error) {
+			fmt.Println("Error connecting to OBS", err)
+		},
+	}
+	reconnector.Run(stop, b.session)
+}
+
+// session mirrors the mic state to a connection until it is lost or stop is closed
+func (b *OBSBridge) session(client *obs.Client, stop <-chan struct{}) {
+	itemID := 0
+	//? OBS may have been started after the last change, so it gets the current state first
+	for {
+		//? The item may be created after we connected, so a missing item is looked up again on every change
+		if b.Item != "" && itemID == 0 {
+			itemID = b.findItem(client)
+		}
+		b.mu.Lock()
+		open := b.open
+		b.mu.Unlock()
+		if !b.mirror(client, open, itemID) {
+			itemID = 0
+		}
+		select {
+		case <-stop:
+			return
+		case <-client.Done():
+			return
+		case <-b.changed:
+		case <-b.items:
+			itemID = 0
+		}
+	}
+}
+
+// findItem returns the id of the scene item, 0 if it isn't in the scene
+func (b *OBSBridge) findItem(client *obs.Client) int {
+	var response struct {
+		SceneItemID int `json:"sceneItemId"`
+	}
+	err := client.Request("GetSceneItemId", map[string]string{"sceneName": b.Scene, "sourceName": b.Item}, &response)
+	if err != nil {
+		fmt.Println("Error finding the OBS scene item, trying again on the next change", err)
+		return 0
+	}
+	return response.SceneItemID
+}
+
+// mirror sends the mic state to the input and the scene item, returns false if the scene item couldn't be set
+func (b *OBSBridge) mirror(client *obs.Client, open bool, itemID int) bool {
+	if b.Input != "" {
+		if err := client.Request("SetInputMute", map[string]interface{}{"inputName": b.Input, "inputMuted": !open}, nil); err != nil {
+			fmt.Println("Error muting the OBS input", err)
+		}
+	}
+	if itemID != 0 {
+		err := client.Request("SetSceneItemEnabled", map[string]interface{}{"sceneName": b.Scene, "sceneItemId": itemID, "sceneItemEnabled": open}, nil)
+		if err != nil {
+			fmt.Println("Error showing the OBS scene item", err)
+			return false
+		}
+	}
+	return true
+}
+
+// handleEvent opens and closes the mic when the control input is unmuted and muted in OBS
+// and looks the scene item up again when items of its scene are created or removed
+func (b *OBSBridge) handleEvent(event obs.Event) {
+	switch event.EventType {
+	case "SceneItemCreated", "SceneItemRemoved":
+		var data struct {
+			SceneName string `json:"sceneName"`
+		}
+		if err := json.Unmarshal(event.EventData, &data); err != nil || data.SceneName != b.Scene {
+			return
+		}
+		select {
+		case b.items <- struct{}{}:
+		default:
+		}
+		return
+	case "InputMuteStateChanged":
+	default:
+		return
+	}
+	var data struct {
+		InputName  string `json:"inputName"`
+		InputMuted bool   `json:"inputMuted"`
+	}
+	if err := json.Unmarshal(event.EventData, &data); err != nil || data.InputName != b.Control {
+		return
+	}
+	//? Events are read on the connection goroutine and the engine may block on COM, so they are queued
+	select {
+	case b.commands <- !data.InputMuted:
+	default:
+		fmt.Println("Error OBS command queue is full, dropping mute state", data.InputMuted)
+	}
+}
+
+// runCommands applies the queued control input changes one at a time in the order OBS sent them, until stop is closed
+func (b *OBSBridge) runCommands(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case open := <-b.commands:
+			withOLE(func() { engine.SetOpen(open) })
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Package osc encodes and decodes Open Sound Control 1.0 messages and bundles
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Message is an OSC message, the arguments are int32, int64, float32, float64, string, []byte, bool or nil
+type Message struct {
+	Address string
+	Args    []interface{}
+}
+
+// Bool reads the first argument as on or off, the way control surfaces send buttons: a number, a boolean or a string
+func (m Message) Bool() (value bool, ok bool) {
+	if len(m.Args) == 0 {
+		return false, false
+	}
+	switch arg := m.Args[0].(type) {
+	case int32:
+		return arg != 0, true
+	case int64:
+		return arg != 0, true
+	case float32:
+		return arg >= 0.5, true
+	case float64:
+		return arg >= 0.5, true
+	case bool:
+		return arg, true
+	case string:
+		switch strings.ToLower(arg) {
+		case "1", "on", "true":
+			return true, true
+		case "0", "off", "false":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, s...)
+	return append(b, make([]byte, 4-len(s)%4)...)
+}
+
+func appendBlob(b []byte, data []byte) []byte {
+	b = appendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	return append(b, make([]byte, (4-len(data)%4)%4)...)
+}
+
+// Encode returns the packet of the message
+func (m Message) Encode() ([]byte, error) {
+	if !strings.HasPrefix(m.Address, "/") {
+		return nil, fmt.Errorf("osc: address %q must start with /", m.Address)
+	}
+	tags := ","
+	var args []byte
+	for _, arg := range m.Args {
+		switch arg := arg.(type) {
+		case int32:
+			tags += "i"
+			args = appendUint32(args, uint32(arg))
+		case int:
+			tags += "i"
+			args = appendUint32(args, uint32(int32(arg)))
+		case int64:
+			tags += "h"
+			args = appendUint64(args, uint64(arg))
+		case float32:
+			tags += "f"
+			args = appendUint32(args, math.Float32bits(arg))
+		case float64:
+			tags += "d"
+			args = appendUint64(args, math.Float64bits(arg))
+		case string:
+			tags += "s"
+			args = appendString(args, arg)
+		case []byte:
+			tags += "b"
+			args = appendBlob(args, arg)
+		case bool:
+			if arg {
+				tags += "T"
+			} else {
+				tags += "F"
+			}
+		case nil:
+			tags += "N"
+		default:
+			return nil, fmt.Errorf("osc: unsupported argument type %T", arg)
+		}
+	}
+	packet := appendString(nil, m.Address)
+	packet = appendString(packet, tags)
+	return append(packet, args...), nil
+}
+
+// reader walks the 4 byte aligned fields of a packet
+type reader struct {
+	data []byte
+}
+
+var errTruncated = errors.New("osc: truncated packet")
+
+func (r *reader) string() (string, error) {
+	end := bytes.IndexByte(r.data, 0)
+	if end < 0 {
+		return "", errTruncated
+	}
+	s := string(r.data[:end])
+	size := end + 4 - end%4
+	if size > len(r.data) {
+		return "", errTruncated
+	}
+	r.data = r.data[size:]
+	return s, nil
+}
+
+func (r *reader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data) {
+		return nil, errTruncated
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+func (r *reader) uint32() (uint32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (r *reader) uint64() (uint64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// Decode reads a packet, a bundle gives all of its messages and the messages of the bundles in it
+func Decode(packet []byte) ([]Message, error) {
+	if bytes.HasPrefix(packet, []byte("#bundle\x00")) {
+		return decodeBundle(packet)
+	}
+	message, err := decodeMessage(packet)
+	if err != nil {
+		return nil, err
+	}
+	return []Message{message}, nil
+}
+
+func decodeBundle(packet []byte) ([]Message, error) {
+	//? Time tags are ignored, everything is handled as soon as it arrives
+	if len(packet) < 16 {
+		return nil, errTruncated
+	}
+	r := &reader{data: packet[16:]}
+	messages := []Message{}
+	for len(r.data) > 0 {
+		size, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		element, err := r.next(int(size))
+		if err != nil {
+			return nil, err
+		}
+		elementMessages, err := Decode(element)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, elementMessages...)
+	}
+	return messages, nil
+}
+
+func decodeMessage(packet []byte) (Message, error) {
+	r := &reader{data: packet}
+	address, err := r.string()
+	if err != nil {
+		return Message{}, err
+	}
+	if !strings.HasPrefix(address, "/") {
+		return Message{}, fmt.Errorf("osc: address %q must start with /", address)
+	}
+	message := Message{Address: address}
+	if len(r.data) == 0 {
+		//? Old implementations leave out the type tags of a message without arguments
+		return message, nil
+	}
+	tags, err := r.string()
+	if err != nil {
+		return Message{}, err
+	}
+	if !strings.HasPrefix(tags, ",") {
+		return Message{}, errors.New("osc: missing type tags")
+	}
+	for _, tag := range tags[1:] {
+		var arg interface{}
+		switch tag {
+		case 'i':
+			v, err := r.uint32()
+			if err != nil {
+				return Message{}, err
+			}
+			arg = int32(v)
+		case 'h':
+			v, err := r.uint64()
+			if err != nil {
+				return Message{}, err
+			}
+			arg = int64(v)
+		case 'f':
+			v, err := r.uint32()
+			if err != nil {
+				return Message{}, err
+			}
+			arg = math.Float32frombits(v)
+		case 'd':
+			v, err := r.uint64()
+			if err != nil {
+				return Message{}, err
+			}
+			arg = math.Float64frombits(v)
+		case 's', 'S':
+			if arg, err = r.string(); err != nil {
+				return Message{}, err
+			}
+		case 'b':
+			size, err := r.uint32()
+			if err != nil {
+				return Message{}, err
+			}
+			blob, err := r.next(int(size))
+			if err != nil {
+				return Message{}, err
+			}
+			if _, err := r.next((4 - int(size)%4) % 4); err != nil {
+				return Message{}, err
+			}
+			arg = append([]byte{}, blob...)
+		case 'T':
+			arg = true
+		case 'F':
+			arg = false
+		case 'N', 'I':
+			arg = nil
+		default:
+			return Message{}, fmt.Errorf("osc: unsupported type tag %q", tag)
+		}
+		message.Args = append(message.Args, arg)
+	}
+	return message, nil
+}
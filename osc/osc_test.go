@@ -0,0 +1,183 @@
+package osc
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// bundle wraps encoded elements in a bundle with an immediate time tag
+func bundle(elements ...[]byte) []byte {
+	packet := appendString(nil, "#bundle")
+	packet = appendUint64(packet, 1)
+	for _, element := range elements {
+		packet = appendUint32(packet, uint32(len(element)))
+		packet = append(packet, element...)
+	}
+	return packet
+}
+
+func encode(t *testing.T, m Message) []byte {
+	t.Helper()
+	packet, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestEncodePadding(t *testing.T) {
+	tests := []struct {
+		message Message
+		want    []byte
+	}{
+		//? Strings always end with at least one null, a 3 byte string fits in 4
+		{Message{Address: "/ab"}, []byte("/ab\x00,\x00\x00\x00")},
+		{Message{Address: "/abc"}, []byte("/abc\x00\x00\x00\x00,\x00\x00\x00")},
+		{Message{Address: "/a", Args: []interface{}{int32(1)}}, []byte("/a\x00\x00,i\x00\x00\x00\x00\x00\x01")},
+		{Message{Address: "/a", Args: []interface{}{"xyzw"}}, []byte("/a\x00\x00,s\x00\x00xyzw\x00\x00\x00\x00")},
+		//? Blobs are padded to 4 bytes but need no null
+		{Message{Address: "/a", Args: []interface{}{[]byte{1, 2, 3, 4}}}, []byte("/a\x00\x00,b\x00\x00\x00\x00\x00\x04\x01\x02\x03\x04")},
+		{Message{Address: "/a", Args: []interface{}{[]byte{1}}}, []byte("/a\x00\x00,b\x00\x00\x00\x00\x00\x01\x01\x00\x00\x00")},
+		{Message{Address: "/a", Args: []interface{}{true, false, nil}}, []byte("/a\x00\x00,TFN\x00\x00\x00\x00")},
+	}
+	for _, test := range tests {
+		got := encode(t, test.message)
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%+v encoded to %q, want %q", test.message, got, test.want)
+		}
+		if len(got)%4 != 0 {
+			t.Errorf("%+v encoded to %d bytes, not 4 byte aligned", test.message, len(got))
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if _, err := (Message{Address: "muteiny/ptt"}).Encode(); err == nil {
+		t.Error("address without / encoded")
+	}
+	if _, err := (Message{Address: "/a", Args: []interface{}{struct{}{}}}).Encode(); err == nil {
+		t.Error("unsupported argument encoded")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	messages := []Message{
+		{Address: "/muteiny/toggle"},
+		{Address: "/muteiny/ptt", Args: []interface{}{int32(1)}},
+		{Address: "/a", Args: []interface{}{int32(-5), int64(math.MaxInt64), float32(0.25), float64(-1.5)}},
+		{Address: "/a", Args: []interface{}{"", "abc", "abcd", "abcde"}},
+		{Address: "/a", Args: []interface{}{[]byte{}, []byte{1, 2, 3}, []byte{1, 2, 3, 4, 5}}},
+		{Address: "/a", Args: []interface{}{true, false, nil, "end"}},
+	}
+	for _, message := range messages {
+		decoded, err := Decode(encode(t, message))
+		if err != nil {
+			t.Fatalf("%+v: %v", message, err)
+		}
+		if len(decoded) != 1 || !reflect.DeepEqual(decoded[0], message) {
+			t.Errorf("decoded %+v, want %+v", decoded, message)
+		}
+	}
+
+	//? An int is sent as int32
+	decoded, err := Decode(encode(t, Message{Address: "/a", Args: []interface{}{7}}))
+	if err != nil || !reflect.DeepEqual(decoded[0].Args, []interface{}{int32(7)}) {
+		t.Errorf("int decoded to %+v, %v", decoded, err)
+	}
+}
+
+func TestDecodeWithoutTypeTags(t *testing.T) {
+	decoded, err := Decode([]byte("/muteiny/mute\x00\x00\x00"))
+	if err != nil || len(decoded) != 1 || decoded[0].Address != "/muteiny/mute" || len(decoded[0].Args) != 0 {
+		t.Errorf("decoded %+v, %v", decoded, err)
+	}
+}
+
+func TestDecodeBundle(t *testing.T) {
+	first := Message{Address: "/one", Args: []interface{}{int32(1)}}
+	second := Message{Address: "/two", Args: []interface{}{"b"}}
+	third := Message{Address: "/three"}
+	packet := bundle(encode(t, first), bundle(encode(t, second), bundle(encode(t, third))))
+	decoded, err := Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Message{first, second, third}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded %+v, want %+v", decoded, want)
+	}
+
+	decoded, err = Decode(bundle())
+	if err != nil || len(decoded) != 0 {
+		t.Errorf("empty bundle decoded to %+v, %v", decoded, err)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	valid := encode(t, Message{Address: "/a", Args: []interface{}{int32(1), "text", []byte{1, 2, 3}}})
+	//? Every cut of a valid packet must fail cleanly instead of panicking,
+	//? except right after the address which is a message without type tags
+	for n := 1; n < len(valid); n++ {
+		if _, err := Decode(valid[:n]); err == nil && n != 4 {
+			t.Errorf("packet cut to %d bytes decoded", n)
+		}
+	}
+
+	tests := map[string][]byte{
+		"empty":                   {},
+		"address without /":       []byte("a\x00\x00\x00,\x00\x00\x00"),
+		"address without null":    []byte("/abc"),
+		"tags without comma":      []byte("/a\x00\x00i\x00\x00\x00\x00\x00\x00\x01"),
+		"unknown tag":             []byte("/a\x00\x00,x\x00\x00\x00\x00\x00\x01"),
+		"missing int":             []byte("/a\x00\x00,i\x00\x00"),
+		"missing double":          []byte("/a\x00\x00,d\x00\x00\x00\x00\x00\x01"),
+		"string without null":     []byte("/a\x00\x00,s\x00\x00abcd"),
+		"blob longer than packet": []byte("/a\x00\x00,b\x00\x00\x00\x00\x00\x08\x01\x02\x03\x04"),
+		"blob without padding":    []byte("/a\x00\x00,b\x00\x00\x00\x00\x00\x01\x01"),
+		"huge blob":               []byte("/a\x00\x00,b\x00\x00\xff\xff\xff\xff"),
+		"short bundle":            []byte("#bundle\x00\x00\x00"),
+		"bundle element too long": append(bundle(), 0, 0, 1, 0, '/', 'a', 0, 0),
+		"bundle without size":     append(bundle(), 0, 0),
+		"bundle with bad element": bundle([]byte("a\x00\x00\x00")),
+	}
+	for name, packet := range tests {
+		if messages, err := Decode(packet); err == nil {
+			t.Errorf("%s: decoded %+v", name, messages)
+		}
+	}
+}
+
+func TestBool(t *testing.T) {
+	tests := []struct {
+		arg   interface{}
+		value bool
+		ok    bool
+	}{
+		{int32(1), true, true},
+		{int32(0), false, true},
+		{int64(-1), true, true},
+		{float32(1), true, true},
+		{float32(0.49), false, true},
+		{float64(0.5), true, true},
+		{true, true, true},
+		{false, false, true},
+		{"ON", true, true},
+		{"true", true, true},
+		{"0", false, true},
+		{"off", false, true},
+		{"maybe", false, false},
+		{[]byte{1}, false, false},
+		{nil, false, false},
+	}
+	for _, test := range tests {
+		value, ok := Message{Address: "/a", Args: []interface{}{test.arg}}.Bool()
+		if value != test.value || ok != test.ok {
+			t.Errorf("Bool of %#v = %v, %v, want %v, %v", test.arg, value, ok, test.value, test.ok)
+		}
+	}
+	if _, ok := (Message{Address: "/a"}).Bool(); ok {
+		t.Error("Bool of a message without arguments is ok")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"Muteiny/ipc"
+	"Muteiny/osc"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Largest OSC packet read, the largest UDP payload
+const oscPacketSize = 65535
+
+// Commands waiting to run, more are dropped
+const oscCommandQueue = 16
+
+// OSCBridge runs OSC messages from control surfaces and sends the mic state back to them
+type OSCBridge struct {
+	Prefix   string         // Address prefix, like /muteiny
+	Targets  []*net.UDPAddr // Receivers of the feedback
+	control  *ipc.Server
+	conn     *net.UDPConn // Receives the messages, nil when only sending feedback
+	sender   *net.UDPConn // Sends the feedback
+	commands chan string
+	stop     chan struct{}
+}
+
+// NewOSCBridge listens for OSC on the UDP port of the bind address, port 0 only sends the feedback
+// to the comma separated host:port targets
+func NewOSCBridge(bind string, port int, prefix string, targets string) (*OSCBridge, error) {
+	b := &OSCBridge{Prefix: "/" + strings.Trim(prefix, "/"), control: NewControlServer(), commands: make(chan string, oscCommandQueue), stop: make(chan struct{})}
+	for _, target := range strings.Split(targets, ",") {
+		if strings.TrimSpace(target) == "" {
+			continue
+		}
+		addr, err := net.ResolveUDPAddr("udp", strings.TrimSpace(target))
+		if err != nil {
+			return nil, fmt.Errorf("OSC target %s: %w", target, err)
+		}
+		b.Targets = append(b.Targets, addr)
+	}
+	//? Feedback goes out of its own socket, the listening one may be bound to loopback while the targets are on the network
+	sender, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	b.sender = sender
+	if port == 0 {
+		return b, nil
+	}
+	ip := net.ParseIP(bind)
+	if ip == nil {
+		sender.Close()
+		return nil, fmt.Errorf("OSC bind address %q is not an IP address", bind)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+	if err != nil {
+		sender.Close()
+		return nil, err
+	}
+	b.conn = conn
+	return b, nil
+}
+
+// Serve handles the incoming messages in the order they arrive until Close
+func (b *OSCBridge) Serve() {
+	if b.conn == nil {
+		return
+	}
+	go b.runCommands()
+	buffer := make([]byte, oscPacketSize)
+	for {
+		n, from, err := b.conn.ReadFromUDP(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			continue
+		}
+		messages, err := osc.Decode(buffer[:n])
+		if err != nil {
+			fmt.Println("Error decoding OSC from", from, err)
+			continue
+		}
+		for _, message := range messages {
+			b.handle(message)
+		}
+	}
+}
+
+// handle runs <prefix>/ptt 1|0 as press and release, and <prefix>/toggle, /mute and /unmute
+func (b *OSCBridge) handle(message osc.Message) {
+	if !strings.HasPrefix(message.Address, b.Prefix+"/") {
+		return
+	}
+	var method string
+	value, hasValue := message.Bool()
+	switch strings.TrimPrefix(message.Address, b.Prefix+"/") {
+	case "ptt":
+		if !hasValue {
+			fmt.Println("Error OSC", message.Address, "needs 1 or 0")
+			return
+		}
+		method = "release"
+		if value {
+			method = "press"
+		}
+	case "toggle", "mute", "unmute":
+		//? A button sends 1 when pressed and 0 when let go, only the press counts
+		if hasValue && !value {
+			return
+		}
+		method = strings.TrimPrefix(message.Address, b.Prefix+"/")
+	default:
+		return
+	}
+	//? The engine may block on COM, the next packet shouldn't wait for it, so it is queued
+	select {
+	case b.commands <- method:
+	default:
+		fmt.Println("Error OSC command queue is full, dropping", message.Address)
+	}
+}
+
+// runCommands runs the queued commands one at a time in the order they arrived, until Close
+func (b *OSCBridge) runCommands() {
+	for {
+		select {
+		case <-b.stop:
+			return
+		case method := <-b.commands:
+			if _, err := b.control.Call(method, nil); err != nil {
+				fmt.Println("Error running OSC", method, err)
+			}
+		}
+	}
+}
+
+// Feedback sends the mic state to the targets as <prefix>/open 1|0 and <prefix>/state open|muted|disabled
+func (b *OSCBridge) Feedback(status Status) {
+	open := 0
+	if status.Open {
+		open = 1
+	}
+	messages := []osc.Message{
+		{Address: b.Prefix + "/open", Args: []interface{}{open}},
+		{Address: b.Prefix + "/state", Args: []interface{}{micState(status)}},
+	}
+	for _, message := range messages {
+		packet, err := message.Encode()
+		if err != nil {
+			fmt.Println("Error encoding OSC", err)
+			continue
+		}
+		for _, target := range b.Targets {
+			if _, err := b.sender.WriteToUDP(packet, target); errors.Is(err, net.ErrClosed) {
+				return
+			} else if err != nil {
+				fmt.Println("Error sending OSC to", target, err)
+			}
+		}
+	}
+}
+
+// Close stops Serve
+func (b *OSCBridge) Close() error {
+	close(b.stop)
+	b.sender.Close()
+	if b.conn == nil {
+		return nil
+	}
+	return b.conn.Close()
+}
+
+// RunOSC serves OSC on the port of the bind address and sends feedback after every event until the returned function is called
+func RunOSC(bind string, port int, prefix string, targets string) (func(), error) {
+	bridge, err := NewOSCBridge(bind, port, prefix, targets)
+	if err != nil {
+		return nil, err
+	}
+	if bridge.conn != nil {
+		fmt.Println("OSC listening on", bridge.conn.LocalAddr())
+		go bridge.Serve()
+	}
+	if len(bridge.Targets) > 0 {
+		bridge.Feedback(engine.Status())
+		Subscribe(func(event Event) {
+			bridge.Feedback(engine.Status())
+		})
+	}
+	return func() {
+		bridge.Close()
+	}, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/getlantern/systray"
+)
+
+// Tray menu item that re-arms push-to-talk, only visible while panicked
+var rearmMenu *systray.MenuItem
+
+// IsPanicked returns true while push-to-talk is disabled by the panic binding
+func (e *Engine) IsPanicked() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.panicked
+}
+
+// Panic mutes every active capture device through its mute flag and disables push-to-talk until Rearm
+func (e *Engine) Panic() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.panicked {
+		return
+	}
+	fmt.Println("Panic! Muting every capture device")
+	e.panicked = true
+	e.cancelRelease()
+	e.ptt = false
+
+	//? The mute flag works on every device whatever -mutemode is, and can't be mistaken for a quiet mic
+	devices, releaseAll := GetAllDevices()
+	defer releaseAll()
+	for deviceName, device := range devices {
+		//? A device plugged in after startup has no snapshot yet, take it so shutdown can restore it
+		if _, ok := OriginalMute(deviceName); !ok {
+			RememberOriginalMute(deviceName, GetMute(device))
+		}
+		MarkDeviceUsed(deviceName)
+		fmt.Println("Muting:", deviceName)
+		if err := SetMute(device, true); err != nil {
+			fmt.Println("Error muting:", deviceName, err)
+		}
+	}
+	e.setState(false)
+	SetTrayPanic(true)
+	Publish(Event{Type: "panic", Open: false, Deafened: e.deafened, Device: e.device})
+}
+
+// Rearm enables push-to-talk again after a panic, the other capture devices stay muted until shutdown
+func (e *Engine) Rearm() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.panicked {
+		return
+	}
+	fmt.Println("Push-to-talk re-armed")
+	e.panicked = false
+
+	aev, deviceName, release := GetDefaultDevice()
+	e.useDevice(deviceName, aev)
+	//? The volume based modes leave the mute flag alone, give the default device its original flag back
+	if _, ok := muteStrategy.(*EndpointMute); !ok {
+		if muteState, ok := OriginalMute(deviceName); ok && !muteState {
+			if err := SetMute(aev, false); err != nil {
+				fmt.Println("Error unmuting:", deviceName, err)
+			}
+		}
+	}
+	release()
+	SetTrayPanic(false)
+	e.apply()
+	Publish(Event{Type: "rearm", Open: e.open, Deafened: e.deafened, Device: e.device})
+}
+
+// TogglePanic panics or re-arms, used by -panickey
+func (e *Engine) TogglePanic() {
+	if e.IsPanicked() {
+		e.Rearm()
+	} else {
+		e.Panic()
+	}
+}
+
+// Whether the tray shows the panic state
+var trayPanicked bool
+
+// SetTrayPanic shows or clears the panic state in the tray
+func SetTrayPanic(panicked bool) {
+	trayPanicked = panicked
+	if !systrayActive {
+		return
+	}
+	SetTrayIcon(trayMuted)
+	if panicked {
+		systray.SetTooltip("Muteiny: PANIC, every mic is muted")
+		if rearmMenu != nil {
+			rearmMenu.Show()
+		}
+	} else {
+		systray.SetTooltip("Muteiny")
+		if rearmMenu != nil {
+			rearmMenu.Hide()
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"Muteiny/sound"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	winmm         = windows.NewLazySystemDLL("winmm.dll")
+	procPlaySound = winmm.NewProc("PlaySoundW")
+)
+
+// WinMMPlayer plays sounds through PlaySound on the default output device
+type WinMMPlayer struct {
+	mu      sync.Mutex
+	playing []byte // PlaySound reads from this while playing async, it must stay alive
+}
+
+func (p *WinMMPlayer) Play(cue *sound.Sound) error {
+	const (
+		SND_ASYNC     = 0x0001
+		SND_MEMORY    = 0x0004
+		SND_NODEFAULT = 0x0002
+	)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	//? The previous sound may still be playing until PlaySound stops it
+	previous := p.playing
+	p.playing = cue.EncodeWAV()
+	res, _, err := procPlaySound.Call(uintptr(unsafe.Pointer(&p.playing[0])), 0, SND_ASYNC|SND_MEMORY|SND_NODEFAULT)
+	runtime.KeepAlive(previous)
+	if res == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+// ApplyStartupPolicy sets the default device to the state the -startup policy asks for
+//
+//	mute: force the mic muted until push-to-talk opens it (default)
+//	keep: leave the mic as it is until the first push-to-talk release
+//	open: force the mic open until the first push-to-talk release
+func ApplyStartupPolicy(deviceName string, aev *wca.IAudioEndpointVolume) error {
+	policy := StartupPolicy()
+	fmt.Println("Startup policy:", policy)
+	switch policy {
+	case "keep":
+		//? The mic may already be live, the engine has to say so or everything reports it muted
+		engine.mu.Lock()
+		engine.open = !muteStrategy.IsMuted(deviceName, aev)
+		engine.mu.Unlock()
+	case "open":
+		if muteStrategy.IsMuted(deviceName, aev) {
+			ApplyGain(deviceName, aev)
+			if err := muteStrategy.SetMuted(deviceName, aev, false); err != nil {
+				return err
+			}
+		}
+		//? Opened through setState like any other open, so the event is published and the watchdog armed
+		engine.mu.Lock()
+		engine.ptt = !voiceFlag || voicePTTFlag.Value != "mute"
+		engine.remote = true
+		engine.setState(true)
+		engine.mu.Unlock()
+	default:
+		if !muteStrategy.IsMuted(deviceName, aev) { //? Only call mute if the device is not muted
+			return muteStrategy.SetMuted(deviceName, aev, true)
+		}
+	}
+	return nil
+}
+
+// ApplyShutdownPolicy leaves the devices in the state the -shutdown policy asks for
+//
+//	restore: restore the original state of every device Muteiny changed (default)
+//	muted:   mute every device Muteiny changed
+//	asis:    leave every device as it is
+//	muteall: mute every capture device
+//
+// Output devices muted by deafen or lowered by ducking and capture levels changed by a gain preset
+// are always restored, whatever the policy.
+func ApplyShutdownPolicy() {
+	RestoreRenderDevices()
+	if ducker != nil {
+		ducker.Stop()
+	}
+	RestoreAllGain()
+
+	policy := ShutdownPolicy()
+	fmt.Println("Shutdown policy:", policy)
+	devices, releaseAll := GetAllDevices()
+	defer releaseAll()
+
+	switch policy {
+	case "asis":
+		//? Nothing to restore, drop the saved levels so the next launch doesn't restore them either
+		UpdateState(func(state *SavedState) {
+			state.Volumes = make(map[string]float32)
+		})
+	case "muted", "muteall":
+		for deviceName, device := range devices {
+			if policy == "muted" && !IsDeviceUsed(deviceName) {
+				continue
+			}
+			//? Volume based modes get their level back and are muted through the mute flag instead
+			if err := muteStrategy.Restore(deviceName, device); err != nil {
+				fmt.Println("Error restoring:", deviceName, err)
+			}
+			fmt.Println("Muting:", deviceName)
+			if !GetMute(device) {
+				if err := SetMute(device, true); err != nil {
+					fmt.Println("Error setting mute state for:", deviceName, err)
+				}
+			}
+		}
+	default:
+		// Restore the original mute state of the devices
+		fmt.Println("Setting mute to original state before shutdown!")
+		for deviceName, muteState := range UsedDeviceSnapshots() {
+			if devices[deviceName] != nil {
+				fmt.Println("Restoring mute state for:", deviceName, "to:", muteState)
+				if muteState != GetMute(devices[deviceName]) { //? Only set the mute state if it's different from current state
+					if err := SetMute(devices[deviceName], muteState); err != nil {
+						fmt.Println("Error setting mute state for:", deviceName, err)
+					}
+				}
+				if err := muteStrategy.Restore(deviceName, devices[deviceName]); err != nil {
+					fmt.Println("Error restoring:", deviceName, err)
+				}
+			} else {
+				fmt.Println("Device not found:", deviceName)
+			}
+		}
+	}
+}
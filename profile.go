@@ -0,0 +1,178 @@
+package main
+
+import (
+	"Muteiny/webhook"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Profile is a named set of settings stored in profiles.json, selected with -profile.
+// Flags given on the command line override the values of the active profile.
+type Profile struct {
+	Startup  string                 `json:"startup,omitempty"`  // Startup mute policy, see -startup
+	Shutdown string                 `json:"shutdown,omitempty"` // Shutdown mute policy, see -shutdown
+	Gain     map[string]*GainPreset `json:"gain,omitempty"`     // Capture level set on open per device name, "*" for any device
+}
+
+// ProfileConfig is the content of profiles.json
+type ProfileConfig struct {
+	Default  string              `json:"default,omitempty"` // Profile used when -profile is not given
+	Profiles map[string]*Profile `json:"profiles"`
+	Webhooks []*webhook.Target   `json:"webhooks,omitempty"` // Receivers of the events, whatever the profile
+}
+
+var (
+	profileMutex      sync.Mutex
+	profileConfig     = &ProfileConfig{Profiles: make(map[string]*Profile)}
+	activeProfileName = "default"
+	activeProfile     = &Profile{}
+)
+
+// defaultProfilesPath returns %AppData%\Muteiny\profiles.json on Windows
+func defaultProfilesPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "profiles.json"
+	}
+	return filepath.Join(dir, "Muteiny", "profiles.json")
+}
+
+// LoadProfiles reads the profiles file, without one only the built-in default profile exists
+func LoadProfiles(path string) error {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	config := &ProfileConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]*Profile)
+	}
+	for name, profile := range config.Profiles {
+		if profile == nil {
+			return fmt.Errorf("profile %s is empty", name)
+		}
+		if err := profile.validate(); err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+	}
+	for i, target := range config.Webhooks {
+		if target == nil {
+			return fmt.Errorf("webhook %d is empty", i+1)
+		}
+		if err := target.Validate(); err != nil {
+			return fmt.Errorf("webhook %d: %w", i+1, err)
+		}
+	}
+	profileConfig = config
+	return nil
+}
+
+// validate checks the values of the profile against the flag choices
+func (p *Profile) validate() error {
+	if p.Startup != "" {
+		if err := startupFlag.Validate(p.Startup); err != nil {
+			return fmt.Errorf("startup %w", err)
+		}
+	}
+	if p.Shutdown != "" {
+		if err := shutdownFlag.Validate(p.Shutdown); err != nil {
+			return fmt.Errorf("shutdown %w", err)
+		}
+	}
+	for deviceName, preset := range p.Gain {
+		if err := preset.validate(); err != nil {
+			return fmt.Errorf("gain for %s: %w", deviceName, err)
+		}
+	}
+	return nil
+}
+
+// SetProfile makes the named profile active, an empty name selects the default of the profiles file
+func SetProfile(name string) error {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+
+	if name == "" {
+		name = profileConfig.Default
+	}
+	if name == "" {
+		name = "default"
+	}
+	profile, ok := profileConfig.Profiles[name]
+	if !ok {
+		if name != "default" {
+			return fmt.Errorf("unknown profile %q", name)
+		}
+		profile = &Profile{}
+	}
+	//? Checked before the switch, a profile switched to at runtime skips the startup checks
+	if err := CheckGainMode(profile); err != nil {
+		return fmt.Errorf("profile %q: %w", name, err)
+	}
+	activeProfileName = name
+	activeProfile = profile
+	return nil
+}
+
+// ActiveProfile returns the name and settings of the active profile
+func ActiveProfile() (string, *Profile) {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	return activeProfileName, activeProfile
+}
+
+// ProfileNames returns the names of all profiles, sorted
+func ProfileNames() []string {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	names := []string{}
+	for name := range profileConfig.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profileChoice returns the flag value if it was given, else the profile value, else the flag default
+func profileChoice(flag *ChoiceFlag, profileValue string) string {
+	if flag.IsSet || profileValue == "" {
+		return flag.Value
+	}
+	return profileValue
+}
+
+// StartupPolicy returns the startup mute policy of the active profile
+func StartupPolicy() string {
+	_, profile := ActiveProfile()
+	return profileChoice(&startupFlag, profile.Startup)
+}
+
+// ShutdownPolicy returns the shutdown mute policy of the active profile
+func ShutdownPolicy() string {
+	_, profile := ActiveProfile()
+	return profileChoice(&shutdownFlag, profile.Shutdown)
+}
+
+// WebhookTargets returns the webhooks of the profiles file
+func WebhookTargets() []webhook.Target {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	targets := []webhook.Target{}
+	for _, target := range profileConfig.Webhooks {
+		targets = append(targets, *target)
+	}
+	return targets
+}
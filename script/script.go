@@ -0,0 +1,44 @@
+// Package script builds what an event script is given: MUTEINY_ environment variables and the event as JSON on stdin
+package script
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Event is what a script is told about an event
+type Event struct {
+	Type     string    `json:"type"`
+	Open     bool      `json:"open"`
+	Deafened bool      `json:"deafened"`
+	Device   string    `json:"device"`
+	Profile  string    `json:"profile,omitempty"` // The new profile of a "profile" event
+	Time     time.Time `json:"time"`
+}
+
+// Env is the event as MUTEINY_ environment variables. Events that don't change the profile
+// report activeProfile, so MUTEINY_PROFILE is always set.
+func Env(event Event, activeProfile string) []string {
+	profile := event.Profile
+	if profile == "" {
+		profile = activeProfile
+	}
+	return []string{
+		"MUTEINY_EVENT=" + event.Type,
+		"MUTEINY_OPEN=" + strconv.FormatBool(event.Open),
+		"MUTEINY_DEAFENED=" + strconv.FormatBool(event.Deafened),
+		"MUTEINY_DEVICE=" + event.Device,
+		"MUTEINY_PROFILE=" + profile,
+		"MUTEINY_TIME=" + event.Time.Format(time.RFC3339),
+	}
+}
+
+// Payload is the event as one line of JSON, the stdin of a script
+func Payload(event Event) ([]byte, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return append(payload, '\n'), nil
+}
@@ -0,0 +1,83 @@
+package script
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var eventTime = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+func TestEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   Event
+		profile string
+		want    []string
+	}{
+		{
+			"open",
+			Event{Type: "open", Open: true, Device: "Microphone (USB)", Time: eventTime},
+			"default",
+			[]string{"MUTEINY_EVENT=open", "MUTEINY_OPEN=true", "MUTEINY_DEAFENED=false", "MUTEINY_DEVICE=Microphone (USB)", "MUTEINY_PROFILE=default", "MUTEINY_TIME=2024-05-01T12:30:00Z"},
+		},
+		{
+			"deafen",
+			Event{Type: "deafen", Deafened: true, Time: eventTime},
+			"",
+			[]string{"MUTEINY_EVENT=deafen", "MUTEINY_OPEN=false", "MUTEINY_DEAFENED=true", "MUTEINY_DEVICE=", "MUTEINY_PROFILE=", "MUTEINY_TIME=2024-05-01T12:30:00Z"},
+		},
+		{
+			//? The new profile of a profile event wins over the active one
+			"profile",
+			Event{Type: "profile", Device: "Headset", Profile: "gaming", Time: eventTime},
+			"streaming",
+			[]string{"MUTEINY_EVENT=profile", "MUTEINY_OPEN=false", "MUTEINY_DEAFENED=false", "MUTEINY_DEVICE=Headset", "MUTEINY_PROFILE=gaming", "MUTEINY_TIME=2024-05-01T12:30:00Z"},
+		},
+		{
+			"local time",
+			Event{Type: "close", Time: time.Date(2024, 5, 1, 14, 30, 0, 0, time.FixedZone("CEST", 2*60*60))},
+			"default",
+			[]string{"MUTEINY_EVENT=close", "MUTEINY_OPEN=false", "MUTEINY_DEAFENED=false", "MUTEINY_DEVICE=", "MUTEINY_PROFILE=default", "MUTEINY_TIME=2024-05-01T14:30:00+02:00"},
+		},
+	}
+	for _, test := range tests {
+		if got := Env(test.event, test.profile); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Env = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{
+			"open",
+			Event{Type: "open", Open: true, Device: "Microphone (USB)", Time: eventTime},
+			`{"type":"open","open":true,"deafened":false,"device":"Microphone (USB)","time":"2024-05-01T12:30:00Z"}` + "\n",
+		},
+		{
+			"profile",
+			Event{Type: "profile", Deafened: true, Device: "Headset", Profile: "gaming", Time: eventTime},
+			`{"type":"profile","open":false,"deafened":true,"device":"Headset","profile":"gaming","time":"2024-05-01T12:30:00Z"}` + "\n",
+		},
+		{
+			//? Quotes and newlines in names are escaped, the payload stays one line
+			"escaped",
+			Event{Type: "device", Device: "Mic \"A\"\nB", Time: eventTime},
+			`{"type":"device","open":false,"deafened":false,"device":"Mic \"A\"\nB","time":"2024-05-01T12:30:00Z"}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		got, err := Payload(test.event)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: Payload = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"Muteiny/script"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ScriptHooks runs user commands when events happen
+type ScriptHooks struct {
+	Commands map[string]string // Command line per event type
+	Timeout  time.Duration     // A script running longer is killed
+
+	slots chan struct{}
+}
+
+// NewScriptHooks creates the hooks, at most limit scripts run at the same time
+func NewScriptHooks(commands map[string]string, timeout time.Duration, limit int) *ScriptHooks {
+	if limit < 1 {
+		limit = 1
+	}
+	return &ScriptHooks{Commands: commands, Timeout: timeout, slots: make(chan struct{}, limit)}
+}
+
+// Handle starts the script of the event, it waits for a free slot so scripts start in the order of their events
+func (h *ScriptHooks) Handle(event Event) {
+	command := h.Commands[event.Type]
+	if command == "" {
+		return
+	}
+	h.slots <- struct{}{}
+	go func() {
+		defer func() { <-h.slots }()
+		if err := h.run(command, event); err != nil {
+			fmt.Printf("Error running the %s script: %v\n", event.Type, err)
+		}
+	}()
+}
+
+// run runs a command line with the event in its environment and as JSON on stdin.
+// The script runs in a job object, so a timeout kills everything it started and not only cmd.
+func (h *ScriptHooks) run(command string, event Event) error {
+	input, err := script.Payload(script.Event(event))
+	if err != nil {
+		return err
+	}
+	job, err := newScriptJob()
+	if err != nil {
+		return err
+	}
+	defer job.Release()
+
+	shell := os.Getenv("COMSPEC")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	cmd := exec.Command(shell)
+	//? The command line goes to cmd as written, Go's argument quoting would break quoted paths.
+	//? The console window is hidden, a script flashing one on every key press would be unusable.
+	//? It starts suspended so it can't start anything before it is in the job.
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd /d /s /c "` + command + `"`, HideWindow: true, CreationFlags: windows.CREATE_NO_WINDOW | windows.CREATE_SUSPENDED}
+	activeProfile, _ := ActiveProfile()
+	cmd.Env = append(os.Environ(), script.Env(script.Event(event), activeProfile)...)
+	cmd.Stdin = bytes.NewReader(input)
+	//? Script output goes where ours goes, the console or the log
+	if os.Stdout != nil {
+		cmd.Stdout = os.Stdout
+	}
+	if os.Stderr != nil {
+		cmd.Stderr = os.Stderr
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := job.Start(uint32(cmd.Process.Pid)); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	timeout := time.NewTimer(h.Timeout)
+	defer timeout.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timeout.C:
+		job.Kill()
+		<-done
+		return fmt.Errorf("killed after %v", h.Timeout)
+	}
+}
+
+// scriptJob is the job object of a script, every process the script starts is in it too
+type scriptJob struct {
+	handle windows.Handle
+}
+
+// newScriptJob creates a job that kills its processes when its handle is closed, also when Muteiny exits during a script
+func newScriptJob() (*scriptJob, error) {
+	handle, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	job := &scriptJob{handle: handle}
+	if err := job.setLimits(windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE); err != nil {
+		windows.CloseHandle(handle)
+		return nil, err
+	}
+	return job, nil
+}
+
+func (j *scriptJob) setLimits(flags uint32) error {
+	var info windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	info.BasicLimitInformation.LimitFlags = flags
+	_, err := windows.SetInformationJobObject(j.handle, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+	return err
+}
+
+// Start puts the suspended process in the job and resumes it
+func (j *scriptJob) Start(pid uint32) error {
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, pid)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(process)
+	if err := windows.AssignProcessToJobObject(j.handle, process); err != nil {
+		return err
+	}
+	return resumeProcess(pid)
+}
+
+// Kill ends every process in the job
+func (j *scriptJob) Kill() {
+	if err := windows.TerminateJobObject(j.handle, 1); err != nil {
+		fmt.Println("Error killing the script", err)
+	}
+}
+
+// Release closes the job, what a finished script left running in the background keeps running
+func (j *scriptJob) Release() {
+	j.setLimits(0)
+	windows.CloseHandle(j.handle)
+}
+
+// resumeProcess resumes the threads of a process started with CREATE_SUSPENDED, os/exec doesn't keep the thread handle
+func resumeProcess(pid uint32) error {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(snapshot)
+	entry := windows.ThreadEntry32{Size: uint32(unsafe.Sizeof(windows.ThreadEntry32{}))}
+	resumed := false
+	for err = windows.Thread32First(snapshot, &entry); err == nil; err = windows.Thread32Next(snapshot, &entry) {
+		if entry.OwnerProcessID != pid {
+			continue
+		}
+		thread, err := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, entry.ThreadID)
+		if err != nil {
+			return err
+		}
+		_, err = windows.ResumeThread(thread)
+		windows.CloseHandle(thread)
+		if err != nil {
+			return err
+		}
+		resumed = true
+	}
+	if !resumed {
+		return fmt.Errorf("no thread of process %d to resume", pid)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"syscall"
@@ -32,19 +33,20 @@ func InstanceMutex() func() {
 	mutexName := syscall.StringToUTF16Ptr("Global\\MuteinyAppMutex")
 	mutex, err := windows.CreateMutex(nil, false, mutexName)
 
+	//? CreateMutex returns the handle of the existing mutex together with ERROR_ALREADY_EXISTS when another instance holds it
+	if errors.Is(err, windows.ERROR_ALREADY_EXISTS) {
+		windows.CloseHandle(mutex)
+		fmt.Println("Another instance of Muteiny is already running, use a command like 'muteiny toggle' to control it.")
+		MessageBox(0, "Another instance of Muteiny is already running.\n\nUse a command like 'muteiny toggle' or 'muteiny status' to control it.", "Muteiny", 0)
+		os.Exit(1)
+	}
+	//? Access denied means an instance of another user or an elevated one holds it
 	if err != nil {
 		fmt.Println("Error creating mutex:", err)
 		fmt.Println("Another instance of Muteiny is already running.")
 		MessageBox(0, "Another instance of Muteiny is already running.", "Error: Muteiny", 0)
 		os.Exit(1)
 	}
-
-	// If GetLastError returns ERROR_ALREADY_EXISTS, another instance is running
-	if windows.GetLastError() == windows.ERROR_ALREADY_EXISTS {
-		fmt.Println("Another instance of Muteiny is already running.")
-		MessageBox(0, "Another instance of Muteiny is already running.", "Muteiny", 0)
-		os.Exit(1)
-	}
 	return func() {
 		fmt.Println("Closing 'Global\\MuteinyAppMutex' mutex")
 		windows.CloseHandle(mutex)
@@ -0,0 +1,57 @@
+package sound
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Player plays a sound without blocking, a new sound replaces the one playing
+type Player interface {
+	Play(cue *Sound) error
+}
+
+// Cues plays a sound when the mic opens and another when it closes
+type Cues struct {
+	Player  Player
+	Open    *Sound
+	Close   *Sound
+	OnError func(err error) // Called when the player fails, may be nil
+}
+
+// Handle plays the cue of an "open" or "close" event, other events have no cue
+func (c *Cues) Handle(eventType string) {
+	var cue *Sound
+	switch eventType {
+	case "open":
+		cue = c.Open
+	case "close":
+		cue = c.Close
+	}
+	if cue == nil {
+		return
+	}
+	if err := c.Player.Play(cue); err != nil && c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// Sample rate of the generated cue tones
+const CueSampleRate = 44100
+
+// LoadCue reads the wav file at path, or generates a tone when path is empty
+func LoadCue(path string, frequency float64, duration time.Duration, volume float64) (*Sound, error) {
+	if path == "" {
+		return GenerateTone(frequency, duration, volume, CueSampleRate)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	cue, err := DecodeWAV(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cue, nil
+}
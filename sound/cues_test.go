@@ -0,0 +1,94 @@
+package sound
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakePlayer records what it was asked to play
+type fakePlayer struct {
+	played []*Sound
+	err    error
+}
+
+func (p *fakePlayer) Play(cue *Sound) error {
+	p.played = append(p.played, cue)
+	return p.err
+}
+
+func TestCues(t *testing.T) {
+	player := &fakePlayer{}
+	openCue := &Sound{Data: []byte{1}}
+	closeCue := &Sound{Data: []byte{2}}
+	cues := &Cues{Player: player, Open: openCue, Close: closeCue}
+
+	for _, eventType := range []string{"open", "device", "close", "profile", "deafen", "open"} {
+		cues.Handle(eventType)
+	}
+	want := []*Sound{openCue, closeCue, openCue}
+	if len(player.played) != len(want) {
+		t.Fatalf("played %d cues, want %d", len(player.played), len(want))
+	}
+	for i := range want {
+		if player.played[i] != want[i] {
+			t.Errorf("cue %d is %v, want %v", i, player.played[i].Data, want[i].Data)
+		}
+	}
+}
+
+func TestCuesWithoutSound(t *testing.T) {
+	player := &fakePlayer{}
+	cues := &Cues{Player: player, Open: &Sound{}}
+	cues.Handle("close")
+	if len(player.played) != 0 {
+		t.Errorf("played %d cues without a close cue", len(player.played))
+	}
+}
+
+func TestCuesPlayerError(t *testing.T) {
+	player := &fakePlayer{err: errors.New("no device")}
+	var got error
+	cues := &Cues{Player: player, Open: &Sound{}, OnError: func(err error) { got = err }}
+	cues.Handle("open")
+	if got != player.err {
+		t.Errorf("OnError got %v", got)
+	}
+	//? Without OnError the error is dropped
+	cues.OnError = nil
+	cues.Handle("open")
+}
+
+func TestLoadCue(t *testing.T) {
+	tone, err := LoadCue("", 880, 60*time.Millisecond, 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tone.SampleRate != CueSampleRate || tone.Duration() != 60*time.Millisecond {
+		t.Errorf("tone is %d Hz and %v", tone.SampleRate, tone.Duration())
+	}
+
+	want := &Sound{SampleRate: 8000, Channels: 1, BitsPerSample: 8, Data: []byte{1, 2, 3, 4}}
+	path := filepath.Join(t.TempDir(), "cue.wav")
+	if err := os.WriteFile(path, want.EncodeWAV(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wav, err := LoadCue(path, 880, time.Second, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wav.SampleRate != want.SampleRate || string(wav.Data) != string(want.Data) {
+		t.Errorf("loaded %+v, want %+v", wav, want)
+	}
+
+	if _, err := LoadCue(filepath.Join(t.TempDir(), "missing.wav"), 880, time.Second, 0.3); err == nil {
+		t.Error("missing file loaded")
+	}
+	broken := filepath.Join(t.TempDir(), "broken.wav")
+	os.WriteFile(broken, []byte("not a wav"), 0o644)
+	if _, err := LoadCue(broken, 880, time.Second, 0.3); err == nil {
+		t.Error("broken file loaded")
+	}
+}
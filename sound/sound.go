@@ -0,0 +1,184 @@
+// Package sound generates, decodes and encodes uncompressed PCM audio and picks the cue to play for an event.
+package sound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Sound is uncompressed PCM audio
+type Sound struct {
+	SampleRate    uint32
+	Channels      uint16
+	BitsPerSample uint16
+	Data          []byte // Interleaved little endian samples
+}
+
+// Duration returns how long the sound plays
+func (s *Sound) Duration() time.Duration {
+	frameSize := int(s.Channels) * int(s.BitsPerSample) / 8
+	if frameSize == 0 || s.SampleRate == 0 {
+		return 0
+	}
+	frames := len(s.Data) / frameSize
+	return time.Duration(frames) * time.Second / time.Duration(s.SampleRate)
+}
+
+// GenerateTone creates a mono 16 bit sine tone, volume is from 0 to 1.
+// The start and end are faded so the tone doesn't click.
+func GenerateTone(frequency float64, duration time.Duration, volume float64, sampleRate uint32) (*Sound, error) {
+	if duration < 0 {
+		return nil, fmt.Errorf("negative tone duration %v", duration)
+	}
+	if sampleRate == 0 {
+		return nil, errors.New("tone sample rate must be positive")
+	}
+	//? Above 1 the samples would overflow int16 and wrap around
+	if volume < 0 || volume > 1 {
+		return nil, fmt.Errorf("tone volume %v is not between 0 and 1", volume)
+	}
+	samples := int(uint64(duration) * uint64(sampleRate) / uint64(time.Second))
+	fade := int(sampleRate) / 200 // 5ms
+	if fade > samples/2 {
+		fade = samples / 2
+	}
+	data := make([]byte, samples*2)
+	for i := 0; i < samples; i++ {
+		amplitude := volume
+		if i < fade {
+			amplitude *= float64(i) / float64(fade)
+		} else if samples-i <= fade {
+			amplitude *= float64(samples-i-1) / float64(fade)
+		}
+		value := amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate))
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(int16(value*math.MaxInt16)))
+	}
+	return &Sound{SampleRate: sampleRate, Channels: 1, BitsPerSample: 16, Data: data}, nil
+}
+
+// WAV format tag for uncompressed PCM
+const wavFormatPCM = 1
+
+// Largest wav data chunk read, a minute of 48kHz 16 bit stereo is 11 MiB.
+// The size comes from the file, a broken one shouldn't make us allocate gigabytes.
+const MaxDataSize = 64 << 20
+
+// DecodeWAV reads an uncompressed PCM RIFF/WAVE file
+func DecodeWAV(r io.Reader) (*Sound, error) {
+	var header struct {
+		RIFF [4]byte
+		Size uint32
+		WAVE [4]byte
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("reading wav header: %w", err)
+	}
+	if string(header.RIFF[:]) != "RIFF" || string(header.WAVE[:]) != "WAVE" {
+		return nil, errors.New("not a wav file")
+	}
+
+	sound := &Sound{}
+	var haveFormat bool
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &chunk); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("wav file has no data chunk")
+			}
+			return nil, fmt.Errorf("reading wav chunk: %w", err)
+		}
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			var format struct {
+				AudioFormat   uint16
+				Channels      uint16
+				SampleRate    uint32
+				ByteRate      uint32
+				BlockAlign    uint16
+				BitsPerSample uint16
+			}
+			if chunk.Size < 16 {
+				return nil, errors.New("wav format chunk too short")
+			}
+			if err := binary.Read(r, binary.LittleEndian, &format); err != nil {
+				return nil, fmt.Errorf("reading wav format: %w", err)
+			}
+			if format.AudioFormat != wavFormatPCM {
+				return nil, fmt.Errorf("unsupported wav format %d, only PCM is supported", format.AudioFormat)
+			}
+			if format.Channels == 0 || format.SampleRate == 0 || format.BitsPerSample == 0 || format.BitsPerSample%8 != 0 {
+				return nil, errors.New("invalid wav format")
+			}
+			sound.Channels = format.Channels
+			sound.SampleRate = format.SampleRate
+			sound.BitsPerSample = format.BitsPerSample
+			haveFormat = true
+			if err := skip(r, int64(chunk.Size)-16); err != nil {
+				return nil, err
+			}
+		case "data":
+			if !haveFormat {
+				return nil, errors.New("wav data chunk before format chunk")
+			}
+			if chunk.Size > MaxDataSize {
+				return nil, fmt.Errorf("wav data chunk of %d bytes is larger than %d", chunk.Size, MaxDataSize)
+			}
+			sound.Data = make([]byte, chunk.Size)
+			if _, err := io.ReadFull(r, sound.Data); err != nil {
+				return nil, fmt.Errorf("reading wav data: %w", err)
+			}
+			return sound, nil
+		default:
+			if err := skip(r, int64(chunk.Size)); err != nil {
+				return nil, err
+			}
+		}
+		//? Chunks are padded to an even size
+		if chunk.Size%2 == 1 {
+			if err := skip(r, 1); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func skip(r io.Reader, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if _, err := io.CopyN(io.Discard, r, n); err != nil {
+		return fmt.Errorf("reading wav chunk: %w", err)
+	}
+	return nil
+}
+
+// EncodeWAV writes the sound as a PCM RIFF/WAVE file
+func (s *Sound) EncodeWAV() []byte {
+	var buf bytes.Buffer
+	blockAlign := s.Channels * s.BitsPerSample / 8
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(s.Data)))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	binary.Write(&buf, binary.LittleEndian, struct {
+		Size          uint32
+		AudioFormat   uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+	}{16, wavFormatPCM, s.Channels, s.SampleRate, s.SampleRate * uint32(blockAlign), blockAlign, s.BitsPerSample})
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(s.Data)))
+	buf.Write(s.Data)
+	return buf.Bytes()
+}
@@ -0,0 +1,158 @@
+package sound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateTone(t *testing.T) {
+	tone, err := GenerateTone(1000, 100*time.Millisecond, 0.5, 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tone.Channels != 1 || tone.BitsPerSample != 16 || tone.SampleRate != 8000 {
+		t.Fatalf("format = %d channels %d bits %d Hz", tone.Channels, tone.BitsPerSample, tone.SampleRate)
+	}
+	if len(tone.Data) != 800*2 {
+		t.Fatalf("data is %d bytes, want %d", len(tone.Data), 800*2)
+	}
+	if tone.Duration() != 100*time.Millisecond {
+		t.Fatalf("duration = %v", tone.Duration())
+	}
+	var peak int16
+	for i := 0; i < len(tone.Data); i += 2 {
+		sample := int16(binary.LittleEndian.Uint16(tone.Data[i:]))
+		if sample > peak {
+			peak = sample
+		}
+	}
+	if want := int16(math.MaxInt16 / 2); peak > want || peak < want*9/10 {
+		t.Fatalf("peak = %d, want close to %d", peak, want)
+	}
+	//? The fades start and end on silence
+	first := int16(binary.LittleEndian.Uint16(tone.Data[0:]))
+	last := int16(binary.LittleEndian.Uint16(tone.Data[len(tone.Data)-2:]))
+	if first != 0 || last != 0 {
+		t.Fatalf("tone starts at %d and ends at %d, want 0", first, last)
+	}
+}
+
+func TestGenerateToneInvalid(t *testing.T) {
+	if _, err := GenerateTone(1000, -time.Millisecond, 0.5, 8000); err == nil {
+		t.Error("negative duration accepted")
+	}
+	if _, err := GenerateTone(1000, time.Millisecond, 0.5, 0); err == nil {
+		t.Error("zero sample rate accepted")
+	}
+	for _, volume := range []float64{-0.1, 1.5} {
+		if _, err := GenerateTone(1000, time.Millisecond, volume, 8000); err == nil {
+			t.Errorf("volume %v accepted", volume)
+		}
+	}
+	tone, err := GenerateTone(1000, 0, 0.5, 8000)
+	if err != nil || len(tone.Data) != 0 {
+		t.Errorf("zero duration = %v, %v", tone, err)
+	}
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	sounds := []*Sound{
+		{SampleRate: 44100, Channels: 1, BitsPerSample: 16, Data: []byte{1, 2, 3, 4}},
+		{SampleRate: 48000, Channels: 2, BitsPerSample: 24, Data: []byte{1, 2, 3, 4, 5, 6}},
+		{SampleRate: 8000, Channels: 1, BitsPerSample: 8, Data: []byte{1, 2, 3}},
+	}
+	tone, err := GenerateTone(440, 20*time.Millisecond, 1, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sounds = append(sounds, tone)
+	for _, sound := range sounds {
+		decoded, err := DecodeWAV(bytes.NewReader(sound.EncodeWAV()))
+		if err != nil {
+			t.Fatalf("%d Hz %d channels: %v", sound.SampleRate, sound.Channels, err)
+		}
+		if decoded.SampleRate != sound.SampleRate || decoded.Channels != sound.Channels || decoded.BitsPerSample != sound.BitsPerSample || !bytes.Equal(decoded.Data, sound.Data) {
+			t.Fatalf("decoded %+v, want %+v", decoded, sound)
+		}
+	}
+}
+
+// chunk is a RIFF chunk with its padding
+func chunk(id string, data []byte) []byte {
+	out := append([]byte(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// riff wraps the chunks in a RIFF/WAVE header
+func riff(chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return chunk("RIFF", body)
+}
+
+// format is a fmt chunk body, extra bytes follow the 16 of PCM
+func format(audioFormat, channels uint16, sampleRate uint32, bits uint16, extra int) []byte {
+	out := make([]byte, 16+extra)
+	binary.LittleEndian.PutUint16(out[0:], audioFormat)
+	binary.LittleEndian.PutUint16(out[2:], channels)
+	binary.LittleEndian.PutUint32(out[4:], sampleRate)
+	binary.LittleEndian.PutUint32(out[8:], sampleRate*uint32(channels*bits/8))
+	binary.LittleEndian.PutUint16(out[12:], channels*bits/8)
+	binary.LittleEndian.PutUint16(out[14:], bits)
+	return out
+}
+
+func TestDecodeWAV(t *testing.T) {
+	pcm := format(wavFormatPCM, 1, 22050, 16, 0)
+	file, err := DecodeWAV(bytes.NewReader(riff(
+		chunk("LIST", []byte("odd")),
+		chunk("fmt ", format(wavFormatPCM, 2, 22050, 16, 2)),
+		chunk("fact", []byte{1, 2, 3, 4}),
+		chunk("data", []byte{1, 2, 3, 4, 5, 6, 7, 8}),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Channels != 2 || file.SampleRate != 22050 || file.BitsPerSample != 16 || !bytes.Equal(file.Data, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Fatalf("decoded %+v", file)
+	}
+
+	huge := chunk("data", nil)
+	binary.LittleEndian.PutUint32(huge[4:], 0xFFFFFFFF)
+	errors := []struct {
+		name string
+		file []byte
+		want string
+	}{
+		{"empty", nil, "reading wav header"},
+		{"not riff", append([]byte("RIFX\x00\x00\x00\x00WAVE"), chunk("fmt ", pcm)...), "not a wav file"},
+		{"not wave", chunk("RIFF", []byte("AVI ")), "not a wav file"},
+		{"no data", riff(chunk("fmt ", pcm)), "no data chunk"},
+		{"data before format", riff(chunk("data", []byte{1, 2})), "before format"},
+		{"short format", riff(chunk("fmt ", pcm[:14])), "too short"},
+		{"compressed", riff(chunk("fmt ", format(3, 1, 44100, 32, 0))), "unsupported wav format 3"},
+		{"no channels", riff(chunk("fmt ", format(wavFormatPCM, 0, 44100, 16, 0))), "invalid wav format"},
+		{"odd bits", riff(chunk("fmt ", format(wavFormatPCM, 1, 44100, 12, 0))), "invalid wav format"},
+		{"truncated data", riff(chunk("fmt ", pcm), chunk("data", []byte{1, 2, 3, 4}))[:46], "reading wav data"},
+		{"huge data", riff(chunk("fmt ", pcm), huge), "larger than"},
+	}
+	for _, test := range errors {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeWAV(bytes.NewReader(test.file))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
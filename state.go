@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/go-ole/go-ole"
+)
+
+// SavedState is written to disk so the original device settings survive a crash
+type SavedState struct {
+	Volumes          map[string]float32       `json:"volumes,omitempty"`          // Original capture level per device, set by the volume mute mode
+	MuteStates       map[string]bool          `json:"muteStates,omitempty"`       // Original mute state per device, the startup snapshot
+	UsedDevices      map[string]bool          `json:"usedDevices,omitempty"`      // Devices Muteiny has changed, only these are restored
+	RenderMuteStates map[string]bool          `json:"renderMuteStates,omitempty"` // Original mute state of output devices muted by deafen
+	RenderVolumes    map[string]float32       `json:"renderVolumes,omitempty"`    // Original level of output devices lowered by ducking
+	SessionVolumes   map[string]SessionVolume `json:"sessions,omitempty"`         // Original level of application sessions lowered by ducking, per session instance identifier
+	GainVolumes      map[string]float32       `json:"gainVolumes,omitempty"`      // Original capture level of devices changed by a gain preset
+}
+
+// SessionVolume is the original level of an application session, the process name finds it again after a restart
+type SessionVolume struct {
+	Process string  `json:"process"`
+	Level   float32 `json:"level"`
+}
+
+// The state of this session, always in sync with the state file
+var savedState = newSavedState()
+var stateMutex sync.Mutex
+
+// Original mute states left behind by a session that didn't shut down cleanly, waiting to be restored from the tray
+var previousSession map[string]bool
+
+func newSavedState() *SavedState {
+	return &SavedState{
+		Volumes:          make(map[string]float32),
+		MuteStates:       make(map[string]bool),
+		UsedDevices:      make(map[string]bool),
+		RenderMuteStates: make(map[string]bool),
+		RenderVolumes:    make(map[string]float32),
+		SessionVolumes:   make(map[string]SessionVolume),
+		GainVolumes:      make(map[string]float32),
+	}
+}
+
+// statePath returns where the state file is stored, %AppData%\Muteiny\state.json on Windows
+func statePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "Muteiny", "state.json"), nil
+}
+
+// LoadState reads the state file into savedState, a missing file is an empty state
+func LoadState() error {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	state := newSavedState()
+	if err := json.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	//? A file with an empty section leaves the map nil
+	if state.Volumes == nil {
+		state.Volumes = make(map[string]float32)
+	}
+	if state.MuteStates == nil {
+		state.MuteStates = make(map[string]bool)
+	}
+	if state.UsedDevices == nil {
+		state.UsedDevices = make(map[string]bool)
+	}
+	if state.RenderMuteStates == nil {
+		state.RenderMuteStates = make(map[string]bool)
+	}
+	if state.RenderVolumes == nil {
+		state.RenderVolumes = make(map[string]float32)
+	}
+	if state.SessionVolumes == nil {
+		state.SessionVolumes = make(map[string]SessionVolume)
+	}
+	if state.GainVolumes == nil {
+		state.GainVolumes = make(map[string]float32)
+	}
+	savedState = state
+	return nil
+}
+
+// UpdateState changes the saved state and writes it to disk
+func UpdateState(update func(state *SavedState)) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	update(savedState)
+	if err := savedState.save(); err != nil {
+		fmt.Println("Error saving state file", err)
+	}
+}
+
+// ReadState gives read access to the saved state
+func ReadState(read func(state *SavedState)) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	read(savedState)
+}
+
+// save writes the state file, an empty state removes it
+func (s *SavedState) save() error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	if s.IsEmpty() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	//? Write to a temporary file first so a crash mid write doesn't leave a broken state file
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// IsEmpty is true when there is nothing to restore
+func (s *SavedState) IsEmpty() bool {
+	return len(s.Volumes) == 0 && len(s.MuteStates) == 0 && len(s.RenderMuteStates) == 0 &&
+		len(s.RenderVolumes) == 0 && len(s.SessionVolumes) == 0 && len(s.GainVolumes) == 0
+}
+
+// RecoverPreviousSession handles the mute states left in the state file by a session that didn't shut down cleanly.
+// Depending on -recover they are restored now, offered in the tray or dropped. Must run before the new snapshot is taken.
+func RecoverPreviousSession() {
+	var states map[string]bool
+	UpdateState(func(state *SavedState) {
+		for deviceName, muteState := range state.MuteStates {
+			if state.UsedDevices[deviceName] {
+				if states == nil {
+					states = make(map[string]bool)
+				}
+				states[deviceName] = muteState
+			}
+		}
+		state.MuteStates = make(map[string]bool)
+		state.UsedDevices = make(map[string]bool)
+	})
+	if len(states) == 0 {
+		return
+	}
+
+	fmt.Println("Previous session did not shut down cleanly")
+	switch recoverFlag.Value {
+	case "restore":
+		RestoreMuteStates(states)
+	case "tray":
+		fmt.Println("Original mute states can be restored from the tray")
+		previousSession = states
+	default:
+		fmt.Println("Ignoring original mute states of the previous session")
+	}
+}
+
+// RestoreMuteStates sets the devices to the given mute states
+func RestoreMuteStates(states map[string]bool) {
+	devices, releaseAll := GetAllDevices()
+	defer releaseAll()
+	for deviceName, muteState := range states {
+		if device := devices[deviceName]; device != nil {
+			fmt.Println("Restoring mute state for:", deviceName, "to:", muteState)
+			if muteState != GetMute(device) {
+				if err := SetMute(device, muteState); err != nil {
+					fmt.Println("Error setting mute state for:", deviceName, err)
+				}
+			}
+		} else {
+			fmt.Println("Device not found:", deviceName)
+		}
+	}
+}
+
+// RestorePreviousSession restores what RecoverPreviousSession left for the tray.
+// The default device is skipped as it is under push-to-talk control, its original state is restored on shutdown.
+func RestorePreviousSession() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	InitOLE()
+	defer ole.CoUninitialize()
+
+	defaultDevice := engine.DeviceName()
+	states := make(map[string]bool)
+	for deviceName, muteState := range previousSession {
+		if deviceName != defaultDevice {
+			states[deviceName] = muteState
+		}
+	}
+	RestoreMuteStates(states)
+	previousSession = nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"Muteiny/input"
+	"time"
+)
+
+// Restart delays of a failed input source or connection, doubled on every failure in a row
+const (
+	minRestartBackoff = time.Second
+	maxRestartBackoff = 30 * time.Second
+)
+
+// hookSupervisor keeps the input hooks running, a hook that fails or that Windows removed is installed again
+var hookSupervisor = &input.Supervisor{
+	MinBackoff:     minRestartBackoff,
+	MaxBackoff:     maxRestartBackoff,
+	HealthyRunTime: time.Minute,
+	CheckInterval:  250 * time.Millisecond,
+	OnStatus:       reportHookHealth,
+}
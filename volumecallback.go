@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+// go-wca does not implement RegisterControlChangeNotify, so this is a minimal IAudioEndpointVolumeCallback
+// https://learn.microsoft.com/en-us/windows/win32/api/endpointvolume/nn-endpointvolume-iaudioendpointvolumecallback
+
+// Passed as the event context of every change Muteiny makes, so our own changes can be told apart from external ones
+var muteinyEventContext = ole.NewGUID("{5B1C8C5E-3D57-4A51-9C3E-6D7574656979}")
+
+// AUDIO_VOLUME_NOTIFICATION_DATA
+type audioVolumeNotificationData struct {
+	GuidEventContext ole.GUID
+	BMuted           int32
+	FMasterVolume    float32
+	NChannels        uint32
+	AfChannelVolumes [1]float32
+}
+
+type audioEndpointVolumeCallbackVtbl struct {
+	ole.IUnknownVtbl
+	OnNotify uintptr
+}
+
+type AudioEndpointVolumeCallback struct {
+	vTable   *audioEndpointVolumeCallbackVtbl
+	refCount int32
+	onNotify func(muted bool, volume float32, external bool)
+}
+
+var (
+	aevcVtbl     *audioEndpointVolumeCallbackVtbl
+	aevcVtblOnce sync.Once
+)
+
+func aevcQueryInterface(this *AudioEndpointVolumeCallback, riid *ole.GUID, ppInterface *uintptr) uintptr {
+	*ppInterface = 0
+	if ole.IsEqualGUID(riid, ole.IID_IUnknown) || ole.IsEqualGUID(riid, wca.IID_IAudioEndpointVolumeCallback) {
+		aevcAddRef(this)
+		*ppInterface = uintptr(unsafe.Pointer(this))
+		return ole.S_OK
+	}
+	return ole.E_NOINTERFACE
+}
+
+func aevcAddRef(this *AudioEndpointVolumeCallback) uintptr {
+	//? Called from the WASAPI notification thread and ours at the same time
+	return uintptr(atomic.AddInt32(&this.refCount, 1))
+}
+
+func aevcRelease(this *AudioEndpointVolumeCallback) uintptr {
+	return uintptr(atomic.AddInt32(&this.refCount, -1))
+}
+
+func aevcOnNotify(this *AudioEndpointVolumeCallback, pNotify *audioVolumeNotificationData) uintptr {
+	if this.onNotify == nil || pNotify == nil {
+		return ole.S_OK
+	}
+	external := !ole.IsEqualGUID(&pNotify.GuidEventContext, muteinyEventContext)
+	this.onNotify(pNotify.BMuted != 0, pNotify.FMasterVolume, external)
+	return ole.S_OK
+}
+
+// NewAudioEndpointVolumeCallback creates a callback that calls onNotify on every mute or volume change of the endpoint.
+// onNotify is called on a COM thread, it must not call back into the endpoint.
+func NewAudioEndpointVolumeCallback(onNotify func(muted bool, volume float32, external bool)) *AudioEndpointVolumeCallback {
+	aevcVtblOnce.Do(func() {
+		aevcVtbl = &audioEndpointVolumeCallbackVtbl{}
+		aevcVtbl.QueryInterface = syscall.NewCallback(aevcQueryInterface)
+		aevcVtbl.AddRef = syscall.NewCallback(aevcAddRef)
+		aevcVtbl.Release = syscall.NewCallback(aevcRelease)
+		aevcVtbl.OnNotify = syscall.NewCallback(aevcOnNotify)
+	})
+	return &AudioEndpointVolumeCallback{vTable: aevcVtbl, onNotify: onNotify}
+}
+
+// RegisterControlChangeNotify registers the callback with the endpoint
+func RegisterControlChangeNotify(aev *wca.IAudioEndpointVolume, callback *AudioEndpointVolumeCallback) error {
+	hr, _, _ := syscall.SyscallN(
+		aev.VTable().RegisterControlChangeNotify,
+		uintptr(unsafe.Pointer(aev)),
+		uintptr(unsafe.Pointer(callback)))
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
+
+// UnregisterControlChangeNotify removes the callback from the endpoint
+func UnregisterControlChangeNotify(aev *wca.IAudioEndpointVolume, callback *AudioEndpointVolumeCallback) error {
+	hr, _, _ := syscall.SyscallN(
+		aev.VTable().UnregisterControlChangeNotify,
+		uintptr(unsafe.Pointer(aev)),
+		uintptr(unsafe.Pointer(callback)))
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
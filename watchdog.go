@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+// Tray menu item counting the forced closes, only shown with -maxopen
+var watchdogMenu *systray.MenuItem
+
+// startWatchdog force closes the mic after -maxopen, e.mu must be held
+func (e *Engine) startWatchdog() {
+	e.stopWatchdog()
+	if maxOpenFlag.Value <= 0 {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Duration(maxOpenFlag.Value)*time.Second, func() {
+		e.watchdogExpired(timer)
+	})
+	e.watchdog = timer
+}
+
+// stopWatchdog stops the running watchdog, e.mu must be held
+func (e *Engine) stopWatchdog() {
+	if e.watchdog != nil {
+		e.watchdog.Stop()
+		e.watchdog = nil
+	}
+}
+
+func (e *Engine) watchdogExpired(timer *time.Timer) {
+	e.mu.Lock()
+	//? The mic closed and opened again while the timer fired, that one has its own watchdog
+	if e.watchdog != timer || !e.open {
+		e.mu.Unlock()
+		return
+	}
+	e.watchdog = nil
+	fmt.Printf("%s Watchdog: mic was open for more than %vs, closing it. A key release may have been lost\n", time.Now().Format("2006-01-02 15:04:05"), maxOpenFlag.Value)
+	e.cancelRelease()
+	e.ptt = false
+	e.voice = false
+	e.forcedCloses++
+	forcedCloses := e.forcedCloses
+	e.apply()
+	Publish(Event{Type: "watchdog", Open: e.open, Deafened: e.deafened, Device: e.device})
+	e.mu.Unlock()
+
+	if watchdogMenu != nil {
+		watchdogMenu.SetTitle(fmt.Sprintf("Max Open: %vs, closed %v times", maxOpenFlag.Value, forcedCloses))
+	}
+	if err := ShowBalloon("Muteiny", fmt.Sprintf("The mic was open for more than %v seconds and has been closed.", maxOpenFlag.Value)); err != nil {
+		fmt.Println("Error showing notification", err)
+	}
+}
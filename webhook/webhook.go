@@ -0,0 +1,258 @@
+// Package webhook delivers signed JSON payloads to HTTP targets, with an event filter, debouncing and retries
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of a delivery
+const (
+	HeaderEvent     = "X-Muteiny-Event"
+	HeaderTimestamp = "X-Muteiny-Timestamp"
+	HeaderSignature = "X-Muteiny-Signature" // "sha256=" and the hex HMAC of "<timestamp>.<body>"
+)
+
+// Defaults of a Target that leaves them out
+const (
+	DefaultRetries = 3
+	DefaultBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+	requestTimeout = 10 * time.Second
+)
+
+// Event types a debounce applies to, the push-to-talk chatter. Every other event is sent right away.
+var debouncedEvents = map[string]bool{"open": true, "close": true}
+
+// Payloads waiting for delivery, a target that is down longer drops the oldest
+const queueSize = 16
+
+// Target is a webhook receiver
+type Target struct {
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret,omitempty"`   // Key of the signature, no signature without it
+	Events   []string `json:"events,omitempty"`   // Event types sent to the target, all when empty
+	Debounce int      `json:"debounce,omitempty"` // Milliseconds an event waits for a newer one that replaces it
+	Retries  *int     `json:"retries,omitempty"`  // Retries of a failed delivery (default 3)
+	Backoff  int      `json:"backoff,omitempty"`  // Milliseconds before the first retry, doubled on every retry (default 1000)
+}
+
+// Validate checks the target
+func (t *Target) Validate() error {
+	if t.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if t.Debounce < 0 || t.Backoff < 0 || (t.Retries != nil && *t.Retries < 0) {
+		return fmt.Errorf("debounce, retries and backoff must not be negative")
+	}
+	return nil
+}
+
+// Wants reports whether the event type passes the filter
+func (t *Target) Wants(eventType string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, wanted := range t.Events {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign computes the signature header value of a body
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// How old a delivery may be before receivers should refuse it, every attempt is signed with a new timestamp
+const DefaultMaxAge = 5 * time.Minute
+
+// Verify checks the signature of a delivery, for receivers. A timestamp further than maxAge from now
+// is refused too, so a captured request can't be replayed later.
+func Verify(secret string, r *http.Request, body []byte, maxAge time.Duration) bool {
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > maxAge || age < -maxAge {
+		return false
+	}
+	return hmac.Equal([]byte(r.Header.Get(HeaderSignature)), []byte(Sign(secret, timestamp, body)))
+}
+
+type delivery struct {
+	eventType string
+	body      []byte
+}
+
+// Sender delivers the payloads of one target in order
+type Sender struct {
+	Target  Target
+	Client  *http.Client
+	OnError func(err error) // Told about every failed attempt
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  chan delivery
+
+	mu       sync.Mutex
+	debounce *time.Timer
+	latest   *delivery
+}
+
+// NewSender starts the delivery goroutine of a target, Close stops it
+func NewSender(target Target) *Sender {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sender{Target: target, Client: &http.Client{Timeout: requestTimeout}, ctx: ctx, cancel: cancel, queue: make(chan delivery, queueSize)}
+	go s.run()
+	return s
+}
+
+// Send queues the payload as JSON if the target wants the event type.
+// With a debounce only the last open or close of a burst is sent, once the burst is over.
+func (s *Sender) Send(eventType string, payload interface{}) error {
+	if !s.Target.Wants(eventType) {
+		return nil
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if s.Target.Debounce <= 0 || !debouncedEvents[eventType] {
+		s.enqueue(delivery{eventType, body})
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest = &delivery{eventType, body}
+	if s.debounce != nil {
+		s.debounce.Stop()
+	}
+	s.debounce = time.AfterFunc(time.Duration(s.Target.Debounce)*time.Millisecond, func() {
+		s.mu.Lock()
+		latest := s.latest
+		s.latest = nil
+		s.mu.Unlock()
+		if latest != nil {
+			s.enqueue(*latest)
+		}
+	})
+	return nil
+}
+
+func (s *Sender) enqueue(d delivery) {
+	for {
+		select {
+		case s.queue <- d:
+			return
+		default:
+		}
+		//? The receiver is down, the oldest state is the least useful
+		select {
+		case dropped := <-s.queue:
+			s.report(fmt.Errorf("webhook %s: queue full, dropped a %s event", s.Target.URL, dropped.eventType))
+		default:
+		}
+	}
+}
+
+func (s *Sender) report(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+func (s *Sender) run() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case d := <-s.queue:
+			s.deliver(d)
+		}
+	}
+}
+
+// deliver posts a payload, retrying with backoff until it is accepted or the retries run out
+func (s *Sender) deliver(d delivery) {
+	retries := DefaultRetries
+	if s.Target.Retries != nil {
+		retries = *s.Target.Retries
+	}
+	backoff := DefaultBackoff
+	if s.Target.Backoff > 0 {
+		backoff = time.Duration(s.Target.Backoff) * time.Millisecond
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(d)
+		if err == nil {
+			return
+		}
+		s.report(fmt.Errorf("webhook %s: %w", s.Target.URL, err))
+		if !retry || attempt >= retries {
+			return
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// post makes one attempt, retry tells whether another attempt could succeed
+func (s *Sender) post(d delivery) (retry bool, err error) {
+	request, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.Target.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Muteiny")
+	request.Header.Set(HeaderEvent, d.eventType)
+	timestamp := time.Now().Unix()
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if s.Target.Secret != "" {
+		request.Header.Set(HeaderSignature, Sign(s.Target.Secret, timestamp, d.body))
+	}
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("status %s", response.Status)
+	//? A 4xx other than a rate limit won't get better by sending it again
+	return response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Close stops the deliveries, pending payloads are dropped
+func (s *Sender) Close() {
+	s.mu.Lock()
+	if s.debounce != nil {
+		s.debounce.Stop()
+	}
+	s.latest = nil
+	s.mu.Unlock()
+	s.cancel()
+}
@@ -0,0 +1,339 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// received is a delivery as the receiver saw it
+type received struct {
+	event    string
+	body     []byte
+	verified bool
+}
+
+// receiver is a webhook endpoint that answers with the status codes in order, then 200
+type receiver struct {
+	secret string
+
+	mu       sync.Mutex
+	statuses []int
+	got      []received
+	server   *httptest.Server
+	arrived  chan received
+}
+
+func newReceiver(t *testing.T, secret string, statuses ...int) *receiver {
+	r := &receiver{secret: secret, statuses: statuses, arrived: make(chan received, 32)}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *receiver) serve(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	delivery := received{event: req.Header.Get(HeaderEvent), body: body, verified: Verify(r.secret, req, body, DefaultMaxAge)}
+	r.mu.Lock()
+	r.got = append(r.got, delivery)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	r.mu.Unlock()
+	r.arrived <- delivery
+	w.WriteHeader(status)
+}
+
+// Count returns how many requests arrived
+func (r *receiver) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.got)
+}
+
+// Next waits for the next request
+func (r *receiver) Next(t *testing.T) received {
+	t.Helper()
+	select {
+	case delivery := <-r.arrived:
+		return delivery
+	case <-time.After(2 * time.Second):
+		t.Fatal("no delivery")
+	}
+	return received{}
+}
+
+// Quiet checks that no request arrives for a while
+func (r *receiver) Quiet(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case delivery := <-r.arrived:
+		t.Fatalf("unexpected delivery of %s: %s", delivery.event, delivery.body)
+	case <-time.After(wait):
+	}
+}
+
+func retries(n int) *int {
+	return &n
+}
+
+// errorLog collects what a sender reports
+type errorLog struct {
+	mu   sync.Mutex
+	list []error
+}
+
+func (e *errorLog) add(err error) {
+	e.mu.Lock()
+	e.list = append(e.list, err)
+	e.mu.Unlock()
+}
+
+func (e *errorLog) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.list)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"open"}`)
+	now := time.Now().Unix()
+	signature := Sign("secret", now, body)
+	if !strings.HasPrefix(signature, "sha256=") || len(signature) != len("sha256=")+64 {
+		t.Fatalf("signature = %s", signature)
+	}
+	if Sign("secret", now, body) != signature {
+		t.Fatal("signature is not deterministic")
+	}
+
+	request := func(timestamp int64, signature string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+		r.Header.Set(HeaderSignature, signature)
+		return r
+	}
+	stale := now - 600
+	future := now + 600
+	missingTimestamp := request(now, signature)
+	missingTimestamp.Header.Del(HeaderTimestamp)
+	tests := []struct {
+		name    string
+		secret  string
+		request *http.Request
+		body    []byte
+		want    bool
+	}{
+		{"valid", "secret", request(now, signature), body, true},
+		{"wrong secret", "other", request(now, signature), body, false},
+		{"tampered body", "secret", request(now, signature), []byte(`{"type":"close"}`), false},
+		{"replayed with another timestamp", "secret", request(now+1, signature), body, false},
+		{"missing timestamp", "secret", missingTimestamp, body, false},
+		{"missing signature", "secret", request(now, ""), body, false},
+		//? Correctly signed but captured too long ago, or from a clock far ahead
+		{"stale", "secret", request(stale, Sign("secret", stale, body)), body, false},
+		{"from the future", "secret", request(future, Sign("secret", future, body)), body, false},
+		{"within max age", "secret", request(now-60, Sign("secret", now-60, body)), body, true},
+	}
+	for _, test := range tests {
+		if got := Verify(test.secret, test.request, test.body, DefaultMaxAge); got != test.want {
+			t.Errorf("%s: Verify = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDeliverySigned(t *testing.T) {
+	r := newReceiver(t, "secret")
+	sender := NewSender(Target{URL: r.server.URL, Secret: "secret"})
+	defer sender.Close()
+	if err := sender.Send("open", map[string]interface{}{"type": "open", "open": true}); err != nil {
+		t.Fatal(err)
+	}
+	delivery := r.Next(t)
+	if delivery.event != "open" || !delivery.verified {
+		t.Fatalf("delivery of %q, verified %v", delivery.event, delivery.verified)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(delivery.body, &payload); err != nil || payload["open"] != true {
+		t.Fatalf("payload = %s", delivery.body)
+	}
+}
+
+func TestDeliveryUnsigned(t *testing.T) {
+	var signature, timestamp string
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature, timestamp = r.Header.Get(HeaderSignature), r.Header.Get(HeaderTimestamp)
+		close(done)
+	}))
+	defer server.Close()
+	sender := NewSender(Target{URL: server.URL})
+	defer sender.Close()
+	sender.Send("open", map[string]bool{"open": true})
+	<-done
+	if signature != "" {
+		t.Fatal("signed without a secret")
+	}
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		t.Fatalf("timestamp = %q", timestamp)
+	}
+}
+
+func TestRetryOn5xx(t *testing.T) {
+	r := newReceiver(t, "", http.StatusInternalServerError, http.StatusBadGateway, http.StatusTooManyRequests)
+	var errs errorLog
+	sender := NewSender(Target{URL: r.server.URL, Retries: retries(3), Backoff: 1})
+	sender.OnError = errs.add
+	defer sender.Close()
+	sender.Send("open", nil)
+	for i := 0; i < 4; i++ {
+		r.Next(t)
+	}
+	r.Quiet(t, 50*time.Millisecond)
+	if errs.count() != 3 {
+		t.Fatalf("%d errors reported, want one per failed attempt", errs.count())
+	}
+}
+
+func TestRetriesRunOut(t *testing.T) {
+	r := newReceiver(t, "", 500, 500, 500, 500, 500)
+	sender := NewSender(Target{URL: r.server.URL, Retries: retries(2), Backoff: 1})
+	defer sender.Close()
+	sender.Send("open", nil)
+	for i := 0; i < 3; i++ {
+		r.Next(t)
+	}
+	r.Quiet(t, 50*time.Millisecond)
+}
+
+func TestNoRetryOn4xx(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound} {
+		r := newReceiver(t, "", status)
+		var errs errorLog
+		sender := NewSender(Target{URL: r.server.URL, Retries: retries(3), Backoff: 1})
+		sender.OnError = errs.add
+		sender.Send("open", nil)
+		r.Next(t)
+		r.Quiet(t, 30*time.Millisecond)
+		sender.Close()
+		if errs.count() != 1 {
+			t.Fatalf("status %d: %d errors reported, want 1", status, errs.count())
+		}
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	target := Target{Events: []string{"open", "close"}}
+	for event, want := range map[string]bool{"open": true, "close": true, "deafen": false, "profile": false} {
+		if target.Wants(event) != want {
+			t.Errorf("Wants(%s) = %v, want %v", event, !want, want)
+		}
+	}
+	if !(&Target{}).Wants("anything") {
+		t.Error("a target without a filter doesn't want every event")
+	}
+
+	r := newReceiver(t, "")
+	target.URL = r.server.URL
+	sender := NewSender(target)
+	defer sender.Close()
+	for _, event := range []string{"deafen", "open", "profile", "close"} {
+		sender.Send(event, map[string]string{"type": event})
+	}
+	for _, want := range []string{"open", "close"} {
+		if got := r.Next(t).event; got != want {
+			t.Fatalf("delivered %s, want %s", got, want)
+		}
+	}
+	r.Quiet(t, 30*time.Millisecond)
+}
+
+func TestDebounce(t *testing.T) {
+	r := newReceiver(t, "")
+	sender := NewSender(Target{URL: r.server.URL, Debounce: 50})
+	defer sender.Close()
+	for i, event := range []string{"open", "close", "open", "close"} {
+		sender.Send(event, map[string]int{"n": i})
+		time.Sleep(5 * time.Millisecond)
+	}
+	delivery := r.Next(t)
+	if delivery.event != "close" || string(delivery.body) != `{"n":3}` {
+		t.Fatalf("delivered %s %s, want the last event of the burst", delivery.event, delivery.body)
+	}
+	r.Quiet(t, 100*time.Millisecond)
+
+	//? A new burst after the quiet period is delivered too
+	sender.Send("open", map[string]int{"n": 4})
+	if delivery := r.Next(t); string(delivery.body) != `{"n":4}` {
+		t.Fatalf("delivered %s", delivery.body)
+	}
+}
+
+func TestDebounceOnlyOpenAndClose(t *testing.T) {
+	r := newReceiver(t, "")
+	sender := NewSender(Target{URL: r.server.URL, Debounce: 50})
+	defer sender.Close()
+	sender.Send("open", map[string]int{"n": 0})
+	for i, event := range []string{"profile", "device", "panic", "watchdog"} {
+		sender.Send(event, map[string]int{"n": i + 1})
+	}
+	sender.Send("close", map[string]int{"n": 5})
+	//? The other events are not held back by the pending open and close
+	for _, want := range []string{"profile", "device", "panic", "watchdog", "close"} {
+		if delivery := r.Next(t); delivery.event != want {
+			t.Fatalf("delivered %s %s, want %s", delivery.event, delivery.body, want)
+		}
+	}
+	r.Quiet(t, 100*time.Millisecond)
+}
+
+func TestDeliveriesInOrder(t *testing.T) {
+	r := newReceiver(t, "")
+	sender := NewSender(Target{URL: r.server.URL})
+	defer sender.Close()
+	for i := 0; i < 10; i++ {
+		sender.Send("open", i)
+	}
+	for i := 0; i < 10; i++ {
+		if got := string(r.Next(t).body); got != strconv.Itoa(i) {
+			t.Fatalf("delivery %d is %s", i, got)
+		}
+	}
+}
+
+func TestCloseDropsPending(t *testing.T) {
+	r := newReceiver(t, "")
+	sender := NewSender(Target{URL: r.server.URL, Debounce: 20})
+	sender.Send("open", nil)
+	sender.Close()
+	r.Quiet(t, 60*time.Millisecond)
+	if r.Count() != 0 {
+		t.Fatal("delivered after Close")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		target Target
+		valid  bool
+	}{
+		{Target{URL: "http://localhost/hook"}, true},
+		{Target{URL: "http://localhost/hook", Retries: retries(0)}, true},
+		{Target{}, false},
+		{Target{URL: "http://localhost/hook", Debounce: -1}, false},
+		{Target{URL: "http://localhost/hook", Backoff: -1}, false},
+		{Target{URL: "http://localhost/hook", Retries: retries(-1)}, false},
+	}
+	for _, test := range tests {
+		if err := test.target.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v", test.target, err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"Muteiny/webhook"
+	"fmt"
+)
+
+// StartWebhooks sends every event to the targets of the profiles file and -webhook until the returned function is called
+func StartWebhooks() func() {
+	targets := WebhookTargets()
+	if webhookFlag.IsSet {
+		targets = append(targets, webhook.Target{URL: webhookFlag.Value, Secret: webhookSecretFlag.Value})
+	}
+	if len(targets) == 0 {
+		return func() {}
+	}
+	senders := []*webhook.Sender{}
+	for _, target := range targets {
+		sender := webhook.NewSender(target)
+		sender.OnError = func(err error) {
+			fmt.Println("Error delivering webhook", err)
+		}
+		senders = append(senders, sender)
+	}
+	Subscribe(func(event Event) {
+		for _, sender := range senders {
+			if err := sender.Send(event.Type, event); err != nil {
+				fmt.Println("Error encoding webhook", err)
+			}
+		}
+	})
+	return func() {
+		for _, sender := range senders {
+			sender.Close()
+		}
+	}
+}
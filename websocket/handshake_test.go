@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echoServer upgrades every request and echoes the messages back
+func echoServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if conn.WriteMessage(opcode, data) != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestDialAndUpgrade(t *testing.T) {
+	server := echoServer(t)
+	conn, err := Dial(wsURL(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteText([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	opcode, data, err := conn.ReadMessage()
+	if err != nil || opcode != OpText || string(data) != "ping" {
+		t.Errorf("echo returned %d %q %v", opcode, data, err)
+	}
+}
+
+func TestUpgradeRefusals(t *testing.T) {
+	server := echoServer(t)
+	valid := func() *http.Request {
+		request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		request.Header.Set("Connection", "keep-alive, Upgrade")
+		request.Header.Set("Upgrade", "websocket")
+		request.Header.Set("Sec-WebSocket-Version", "13")
+		request.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		return request
+	}
+	tests := []struct {
+		name   string
+		change func(r *http.Request)
+		status int
+	}{
+		{"post", func(r *http.Request) { r.Method = http.MethodPost }, http.StatusBadRequest},
+		{"no upgrade header", func(r *http.Request) { r.Header.Del("Upgrade") }, http.StatusBadRequest},
+		{"no connection upgrade", func(r *http.Request) { r.Header.Set("Connection", "keep-alive") }, http.StatusBadRequest},
+		{"old version", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }, http.StatusUpgradeRequired},
+		{"no key", func(r *http.Request) { r.Header.Del("Sec-WebSocket-Key") }, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		request := valid()
+		test.change(request)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, response.StatusCode, test.status)
+		}
+		if test.status == http.StatusUpgradeRequired && response.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("%s: no supported version in the response", test.name)
+		}
+	}
+}
+
+func TestIsUpgradeIsCaseInsensitive(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Connection", "UPGRADE")
+	request.Header.Set("Upgrade", "WebSocket")
+	if !IsUpgrade(request) {
+		t.Error("IsUpgrade refused mixed case headers")
+	}
+}
+
+func TestDialRefusals(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not a websocket"))
+	}))
+	defer plain.Close()
+	if _, err := Dial(wsURL(plain)); err == nil {
+		t.Error("Dial accepted a plain HTTP response")
+	}
+
+	wrongAccept := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-WebSocket-Accept", acceptKey("some other key"))
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+	defer wrongAccept.Close()
+	if _, err := Dial(wsURL(wrongAccept)); err == nil || !strings.Contains(err.Error(), "Sec-WebSocket-Accept") {
+		t.Errorf("Dial with the wrong Sec-WebSocket-Accept returned %v", err)
+	}
+
+	if _, err := Dial("http://127.0.0.1:1"); err == nil {
+		t.Error("Dial accepted an http url")
+	}
+}
@@ -0,0 +1,233 @@
+// Package websocket is a small RFC 6455 implementation, enough for the status API and the OBS client.
+// It has no extensions and no compression.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Largest message ReadMessage accepts
+const MaxMessageSize = 1 << 20
+
+// Largest payload of a ping, pong or close frame
+const maxControlSize = 125
+
+// GUID the accept key is derived with
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// How long a close handshake may take
+const closeTimeout = time.Second
+
+var ErrMessageTooBig = errors.New("websocket: message too big")
+
+// Conn is a websocket connection, ReadMessage must be called from one goroutine, writes from any
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	client bool // Clients mask their frames
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, reader: reader, client: client}
+}
+
+// acceptKey computes Sec-WebSocket-Accept for a Sec-WebSocket-Key
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered, a close frame is answered and returns io.EOF.
+func (c *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	opcode = -1
+	for {
+		fin, frameOpcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOpcode {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			c.writeClose(payload)
+			c.conn.Close()
+			return 0, nil, io.EOF
+		case OpContinuation:
+			if opcode == -1 {
+				return 0, nil, errors.New("websocket: continuation without a message")
+			}
+		default:
+			if opcode != -1 {
+				return 0, nil, errors.New("websocket: new message inside a fragmented one")
+			}
+			opcode = frameOpcode
+		}
+		if len(data)+len(payload) > MaxMessageSize {
+			return 0, nil, ErrMessageTooBig
+		}
+		data = append(data, payload...)
+		if fin {
+			return opcode, data, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > MaxMessageSize {
+		return false, 0, nil, ErrMessageTooBig
+	}
+	//? Control frames can arrive between the frames of a message, so they must fit in one
+	if opcode&0x8 != 0 && (!fin || length > maxControlSize) {
+		return false, 0, nil, errors.New("websocket: fragmented or oversized control frame")
+	}
+	//? Clients must mask and servers must not
+	if masked == c.client {
+		return false, 0, nil, errors.New("websocket: wrong frame masking")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends one unfragmented message
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.writeFrame(opcode, data)
+}
+
+// WriteText sends a text message
+func (c *Conn) WriteText(data []byte) error {
+	return c.WriteMessage(OpText, data)
+}
+
+// writeFrame writes a final frame, writeMu must be held
+func (c *Conn) writeFrame(opcode int, data []byte) error {
+	frame := make([]byte, 0, 14+len(data))
+	frame = append(frame, 0x80|byte(opcode))
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(data) < 126:
+		frame = append(frame, maskBit|byte(len(data)))
+	case len(data) <= 0xFFFF:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(data)))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(data)))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, data...)
+		for i := range data {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, data...)
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// writeClose answers or starts the close handshake
+func (c *Conn) writeClose(payload []byte) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	c.writeFrame(OpClose, payload)
+}
+
+// Close sends a normal close frame and closes the connection
+func (c *Conn) Close() error {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, 1000)
+	c.writeClose(payload)
+	return c.conn.Close()
+}
+
+// SetReadDeadline limits how long ReadMessage waits
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) String() string {
+	return fmt.Sprint("websocket ", c.conn.RemoteAddr())
+}
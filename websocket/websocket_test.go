@@ -0,0 +1,283 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipe connects a client and a server Conn in memory, they are closed when the test ends
+func pipe(t *testing.T) (client, server *Conn) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	deadline := time.Now().Add(5 * time.Second)
+	clientConn.SetDeadline(deadline)
+	serverConn.SetDeadline(deadline)
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	return newConn(clientConn, bufio.NewReader(clientConn), true), newConn(serverConn, bufio.NewReader(serverConn), false)
+}
+
+// frame encodes a raw frame, masked with a fixed key when mask is set
+func frame(fin bool, opcode int, payload []byte, mask bool) []byte {
+	var header []byte
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	var maskBit byte
+	if mask {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		header = []byte{first, maskBit | byte(len(payload))}
+	case len(payload) <= 0xFFFF:
+		header = []byte{first, maskBit | 126, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = []byte{first, maskBit | 127, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+	if !mask {
+		return append(header, payload...)
+	}
+	key := []byte{0x12, 0x34, 0x56, 0x78}
+	header = append(header, key...)
+	for i, b := range payload {
+		header = append(header, b^key[i%4])
+	}
+	return header
+}
+
+// writeRaw writes frames to the connection on its own goroutine, net.Pipe blocks until the other side reads
+func writeRaw(c *Conn, frames ...[]byte) {
+	go func() {
+		for _, f := range frames {
+			if _, err := c.conn.Write(f); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// rawFrame is a frame as the other side read it
+type rawFrame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+// collect reads the frames that arrive on c until it fails
+func collect(c *Conn) <-chan rawFrame {
+	frames := make(chan rawFrame, 16)
+	go func() {
+		defer close(frames)
+		for {
+			fin, opcode, payload, err := c.readFrame()
+			if err != nil {
+				return
+			}
+			frames <- rawFrame{fin, opcode, payload}
+		}
+	}()
+	return frames
+}
+
+func nextFrame(t *testing.T, frames <-chan rawFrame) rawFrame {
+	t.Helper()
+	select {
+	case f, ok := <-frames:
+		if !ok {
+			t.Fatal("connection closed before the frame")
+		}
+		return f
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame")
+	}
+	return rawFrame{}
+}
+
+func TestAcceptKey(t *testing.T) {
+	//? The example of RFC 6455 section 1.3
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey = %q", got)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	client, server := pipe(t)
+	//? Each length takes a different header: 7 bit, 16 bit and 64 bit
+	for _, size := range []int{0, 5, 125, 126, 0xFFFF, 0x10000} {
+		data := bytes.Repeat([]byte{'a'}, size)
+		errs := make(chan error, 1)
+		go func() { errs <- client.WriteMessage(OpBinary, data) }()
+		opcode, got, err := server.ReadMessage()
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatalf("size %d: write: %v", size, err)
+		}
+		if opcode != OpBinary || !bytes.Equal(got, data) {
+			t.Fatalf("size %d: got opcode %d and %d bytes", size, opcode, len(got))
+		}
+	}
+
+	go server.WriteText([]byte("hello"))
+	opcode, got, err := client.ReadMessage()
+	if err != nil || opcode != OpText || string(got) != "hello" {
+		t.Fatalf("server to client: %d %q %v", opcode, got, err)
+	}
+}
+
+func TestMasking(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	wire := make(chan []byte, 1)
+	go func() {
+		buffer := make([]byte, 64)
+		n, _ := serverConn.Read(buffer)
+		wire <- buffer[:n]
+	}()
+	go newConn(clientConn, bufio.NewReader(clientConn), true).WriteText([]byte("secret"))
+	//? The bytes on the wire must not be the plain text
+	data := <-wire
+	if data[1]&0x80 == 0 || bytes.Contains(data, []byte("secret")) {
+		t.Errorf("client frame isn't masked: %x", data)
+	}
+	server := newConn(serverConn, bufio.NewReader(bytes.NewReader(data)), false)
+	if _, got, err := server.ReadMessage(); err != nil || string(got) != "secret" {
+		t.Errorf("unmasked to %q %v", got, err)
+	}
+}
+
+func TestWrongMasking(t *testing.T) {
+	client, server := pipe(t)
+	writeRaw(client, frame(true, OpText, []byte("unmasked"), false))
+	if _, _, err := server.ReadMessage(); err == nil {
+		t.Error("server accepted an unmasked frame")
+	}
+
+	client, server = pipe(t)
+	writeRaw(server, frame(true, OpText, []byte("masked"), true))
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Error("client accepted a masked frame")
+	}
+}
+
+func TestFragmentation(t *testing.T) {
+	client, server := pipe(t)
+	frames := collect(client)
+	writeRaw(client,
+		frame(false, OpText, []byte("hel"), true),
+		//? A ping may come between the fragments and is answered right away
+		frame(true, OpPing, []byte("are you there"), true),
+		frame(false, OpContinuation, []byte("lo "), true),
+		frame(true, OpContinuation, []byte("world"), true),
+	)
+	opcode, data, err := server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != OpText || string(data) != "hello world" {
+		t.Errorf("got %d %q", opcode, data)
+	}
+	if f := nextFrame(t, frames); f.opcode != OpPong || string(f.payload) != "are you there" {
+		t.Errorf("ping answered with %d %q", f.opcode, f.payload)
+	}
+}
+
+func TestFragmentationErrors(t *testing.T) {
+	tests := map[string][][]byte{
+		"continuation without a message": {frame(true, OpContinuation, []byte("x"), true)},
+		"new message inside a fragmented one": {
+			frame(false, OpText, []byte("a"), true),
+			frame(true, OpText, []byte("b"), true),
+		},
+	}
+	for name, frames := range tests {
+		client, server := pipe(t)
+		writeRaw(client, frames...)
+		if _, _, err := server.ReadMessage(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestControlFrames(t *testing.T) {
+	client, server := pipe(t)
+	frames := collect(client)
+	writeRaw(client,
+		frame(true, OpPong, []byte("unsolicited"), true),
+		frame(true, OpText, []byte("after pong"), true),
+	)
+	if _, data, err := server.ReadMessage(); err != nil || string(data) != "after pong" {
+		t.Fatalf("got %q %v", data, err)
+	}
+
+	closePayload := []byte{0x03, 0xE8}
+	writeRaw(client, frame(true, OpClose, closePayload, true))
+	if _, _, err := server.ReadMessage(); err != io.EOF {
+		t.Fatalf("close returned %v, want io.EOF", err)
+	}
+	if f := nextFrame(t, frames); f.opcode != OpClose || !bytes.Equal(f.payload, closePayload) {
+		t.Errorf("close answered with %d %x", f.opcode, f.payload)
+	}
+	if err := server.WriteText([]byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("write after close returned %v", err)
+	}
+}
+
+func TestInvalidControlFrames(t *testing.T) {
+	tests := map[string][]byte{
+		"fragmented ping": frame(false, OpPing, []byte("x"), true),
+		"oversized ping":  frame(true, OpPing, bytes.Repeat([]byte{'x'}, maxControlSize+1), true),
+	}
+	for name, f := range tests {
+		client, server := pipe(t)
+		writeRaw(client, f)
+		if _, _, err := server.ReadMessage(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestMessageTooBig(t *testing.T) {
+	client, server := pipe(t)
+	//? Only the header is sent, the length alone has to be refused before anything is allocated
+	header := []byte{0x80 | OpBinary, 0x80 | 127, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(header[2:], MaxMessageSize+1)
+	writeRaw(client, header)
+	if _, _, err := server.ReadMessage(); !errors.Is(err, ErrMessageTooBig) {
+		t.Errorf("oversized frame returned %v", err)
+	}
+
+	client, server = pipe(t)
+	half := bytes.Repeat([]byte{'x'}, MaxMessageSize/2+1)
+	writeRaw(client, frame(false, OpBinary, half, true), frame(true, OpContinuation, half, true))
+	if _, _, err := server.ReadMessage(); !errors.Is(err, ErrMessageTooBig) {
+		t.Errorf("oversized fragmented message returned %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	client, server := pipe(t)
+	frames := collect(server)
+	client.Close()
+	f := nextFrame(t, frames)
+	if f.opcode != OpClose || len(f.payload) != 2 || binary.BigEndian.Uint16(f.payload) != 1000 {
+		t.Errorf("close frame %d %x", f.opcode, f.payload)
+	}
+	if err := client.WriteText([]byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("write after Close returned %v", err)
+	}
+}